@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drive-deep/task-microservice/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// backend connects a cache for a test, closed when the test ends.
+type backend struct {
+	name string
+	// shards is the number of keyspace shards of Redis backends.
+	shards  int
+	connect func(t testing.TB, cfg config.RedisConfig) Cache
+}
+
+// backends are the caches the service ships, each as it is deployed.
+var backends = []backend{
+	{"memory", 0, func(t testing.TB, cfg config.RedisConfig) Cache {
+		return connect(t, NewMemoryCache(cfg))
+	}},
+	{"redis", 1, func(t testing.TB, cfg config.RedisConfig) Cache {
+		cfg.Addr = miniredis.RunT(t).Addr()
+		return connect(t, NewRedisCache(cfg))
+	}},
+	// Sharded as in cluster mode, whose listings merge every shard.
+	{"redis-sharded", 4, func(t testing.TB, cfg config.RedisConfig) Cache {
+		cfg.Addr = miniredis.RunT(t).Addr()
+		cfg.Shards = 4
+		return connect(t, NewRedisCache(cfg))
+	}},
+	{"tiered", 1, func(t testing.TB, cfg config.RedisConfig) Cache {
+		cfg.Addr = miniredis.RunT(t).Addr()
+		return connectTiered(t, cfg)
+	}},
+}
+
+// testConfig returns the settings of a test cache holding up to maxEntries
+// tasks.
+func testConfig(maxEntries int) config.RedisConfig {
+	return config.RedisConfig{
+		KeyPrefix:      "test",
+		SchemaVersion:  DefaultSchemaVersion,
+		EvictionPolicy: EvictionLRU,
+		MaxEntries:     maxEntries,
+		NegativeTTL:    time.Minute,
+		Timeout:        5 * time.Second,
+	}
+}
+
+func connect(t testing.TB, c Cache) Cache {
+	t.Helper()
+	connected, err := c.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect cache: %v", err)
+	}
+	t.Cleanup(func() { connected.Close() })
+	return connected
+}
+
+// connectTiered connects a local tier in front of the Redis at cfg.Addr,
+// behind a ResilientCache, as connectCache in cmd does.
+func connectTiered(t testing.TB, cfg config.RedisConfig) Cache {
+	t.Helper()
+	redisCache := NewRedisCache(cfg)
+	if _, err := redisCache.Connect(); err != nil {
+		t.Fatalf("failed to connect cache: %v", err)
+	}
+	tiered := connect(t, NewTieredCache(redisCache, NewRedisInvalidator(redisCache), cfg.MaxEntries, time.Minute))
+	return NewResilientCache(tiered, "redis", 5, time.Second, true)
+}
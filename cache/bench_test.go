@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// benchTasks is the number of distinct tasks the benchmarks cache, all
+// fitting in the cache so writes don't evict.
+const benchTasks = 1000
+
+// benchRedis returns a RedisCache on a fresh miniredis. Every call is a real
+// round trip over loopback, so the numbers mostly count round trips.
+func benchRedis(b *testing.B) *RedisCache {
+	cfg := testConfig(benchTasks)
+	cfg.Addr = miniredis.RunT(b).Addr()
+	r := NewRedisCache(cfg)
+	connect(b, r)
+	return r
+}
+
+func BenchmarkAddTask(b *testing.B) {
+	r := benchRedis(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.AddTask(ctx, testTask(fmt.Sprint(i%benchTasks), i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetPaginatedTasks(b *testing.B) {
+	const pageSize = 20
+	r := benchRedis(b)
+	ctx := context.Background()
+	for i := 0; i < benchTasks; i++ {
+		if err := r.AddTask(ctx, testTask(fmt.Sprint(i), i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tasks, err := r.GetPaginatedTasks(ctx, i%(benchTasks/pageSize)+1, pageSize)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(tasks) != pageSize {
+			b.Fatalf("got %d tasks, want %d", len(tasks), pageSize)
+		}
+	}
+}
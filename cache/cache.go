@@ -1,17 +1,79 @@
 package cache
 
 import (
+    "context"
+    "errors"
+
     "github.com/drive-deep/task-microservice/models"
 )
 
 type Task = models.Task
 
+// ErrNotFound is returned by GetTask for IDs recently found not to exist.
+var ErrNotFound = errors.New("cache: task does not exist")
+
+// ErrNotCached is returned when a task or listing can't be answered from the
+// cache alone and has to be read from the database.
+var ErrNotCached = errors.New("cache: not cached")
+
+// Backends selectable with config.CacheConfig.Backend.
+const (
+    BackendRedis  = "redis"
+    BackendMemory = "memory"
+    BackendNone   = "none"
+)
+
+// Fields a ListQuery may sort by.
+const (
+    SortCreatedAt = "created_at"
+    SortUpdatedAt = "updated_at"
+    SortPriority  = "priority"
+)
+
+// ListQuery selects a page of cached tasks matching any of the given
+// statuses and priorities, ordered by one indexed field. Empty filters match
+// every task.
+type ListQuery struct {
+    Status   []string
+    Priority []int
+    SortBy   string
+    Desc     bool
+    Page     int
+    PageSize int
+}
+
 type Cache interface {
     Connect() (Cache, error)
     Close() error
-    AddTask(task Task) error
-    GetTask(id string) (Task, error)
-    GetPaginatedTasks(page, pageSize int) ([]Task, error)
-    UpdateTask(task Task) error
-    DeleteTask(id string) error
-}
\ No newline at end of file
+    AddTask(ctx context.Context, task Task) error
+    GetTask(ctx context.Context, id string) (Task, error)
+    // FillTask caches a task read from the database after a miss. Unlike
+    // AddTask it leaves an entry already present, which may be newer.
+    FillTask(ctx context.Context, task Task) error
+    // MarkMissing caches that no task has the ID, so GetTask returns
+    // ErrNotFound until the entry expires or the task is written.
+    MarkMissing(ctx context.Context, id string) error
+    GetPaginatedTasks(ctx context.Context, page, pageSize int) ([]Task, error)
+    // GetFilteredTasks returns ErrNotCached unless every task is cached.
+    GetFilteredTasks(ctx context.Context, q ListQuery) ([]Task, error)
+    UpdateTask(ctx context.Context, task Task) error
+    DeleteTask(ctx context.Context, id string) error
+    // DeleteTasks removes the tasks with ids in as few round trips as the
+    // backend allows. Unlike WriteBatch it doesn't mark them missing.
+    DeleteTasks(ctx context.Context, ids []string) error
+    // Clear removes every cached task, leaving the cache incomplete.
+    Clear(ctx context.Context) error
+    // WriteBatch stores tasks like UpdateTask and removes the tasks with
+    // the IDs in deleted, marking them missing like MarkMissing, in as few
+    // round trips as the backend allows.
+    WriteBatch(ctx context.Context, tasks []Task, deleted []string) error
+    // BeginRebuild starts writing every task to the cache and returns a
+    // token for CompleteRebuild.
+    BeginRebuild(ctx context.Context) (string, error)
+    // CompleteRebuild marks the cache as holding every task, enabling
+    // GetFilteredTasks, unless anything was evicted since BeginRebuild
+    // returned token. It reports whether the cache was marked.
+    CompleteRebuild(ctx context.Context, token string) (bool, error)
+    // IsComplete reports whether the cache holds every task.
+    IsComplete(ctx context.Context) (bool, error)
+}
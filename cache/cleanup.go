@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/drive-deep/task-microservice/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Keys of the layout used before keys were namespaced: tasks were stored
+// under their bare ID and the indexes under fixed global names.
+var (
+	legacyIndexKeys = []string{
+		"tasks", "tasks:meta", "tasks:access", "tasks:complete",
+		"tasks:by:updated_at", "tasks:by:priority",
+	}
+	legacyIndexPatterns = []string{"tasks:status:*", "tasks:priority:*", "tasks:tmp:*"}
+)
+
+// cleanupBatch is the number of keys read or deleted per round trip.
+const cleanupBatch = 500
+
+// CleanupReport counts what a cleanup did, or would do in a dry run.
+type CleanupReport struct {
+	// Migrated is the number of tasks copied into the current keyspace.
+	Migrated int
+	// Deleted is the number of keys deleted.
+	Deleted int
+}
+
+// MigrateLegacyKeys copies tasks cached under the legacy unprefixed layout
+// into the current keyspace and deletes the legacy keys. Only task IDs found
+// in the legacy indexes are touched, so unrelated keys sharing the database
+// are left alone. Tasks already cached in the current keyspace are kept.
+func (r *RedisCache) MigrateLegacyKeys(ctx context.Context, dryRun bool) (CleanupReport, error) {
+	var report CleanupReport
+
+	seen := make(map[string]bool)
+	var ids []string
+	collect := func(members []string) {
+		for _, id := range members {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	members, err := r.client.ZRange(ctx, "tasks", 0, -1).Result()
+	if err != nil {
+		return report, err
+	}
+	collect(members)
+	members, err = r.client.HKeys(ctx, "tasks:meta").Result()
+	if err != nil {
+		return report, err
+	}
+	collect(members)
+
+	for start := 0; start < len(ids); start += cleanupBatch {
+		batch := ids[start:min(start+cleanupBatch, len(ids))]
+		values, err := r.getKeys(ctx, batch)
+		if err != nil {
+			return report, err
+		}
+		var existing []string
+		for i, data := range values {
+			if data == nil {
+				continue
+			}
+			existing = append(existing, batch[i])
+			var task models.Task
+			if *data == missingValue || json.Unmarshal([]byte(*data), &task) != nil {
+				continue
+			}
+			if !dryRun {
+				if err := r.FillTask(ctx, task); err != nil {
+					return report, err
+				}
+			}
+			report.Migrated++
+		}
+		if err := r.deleteKeys(ctx, &report, existing, dryRun); err != nil {
+			return report, err
+		}
+	}
+
+	keys := append([]string(nil), legacyIndexKeys...)
+	for _, pattern := range legacyIndexPatterns {
+		matched, err := r.scanKeys(ctx, pattern)
+		if err != nil {
+			return report, err
+		}
+		keys = append(keys, matched...)
+	}
+	return report, r.deleteKeys(ctx, &report, keys, dryRun)
+}
+
+// DropSchemaVersion deletes every key of another schema version of this
+// cache's namespace, e.g. after all replicas moved to a new version.
+func (r *RedisCache) DropSchemaVersion(ctx context.Context, version int, dryRun bool) (CleanupReport, error) {
+	var report CleanupReport
+	if version == r.keys.version {
+		return report, fmt.Errorf("schema version %d is in use", version)
+	}
+	old := newKeyspace(r.keys.prefix, version, 1)
+	// Keyspaces were named without the hash tag braces before cluster
+	// support, and with a single hash tag before sharding; match every form.
+	untagged := strings.NewReplacer("{", "", "}", "").Replace(old.base)
+	sharded := fmt.Sprintf("{%s:v%d:", old.prefix, old.version)
+	var keys []string
+	for _, base := range []string{old.base, untagged, sharded} {
+		matched, err := r.scanKeys(ctx, escapePattern(base) + "*")
+		if err != nil {
+			return report, err
+		}
+		keys = append(keys, matched...)
+	}
+	return report, r.deleteKeys(ctx, &report, keys, dryRun)
+}
+
+// scanKeys returns the keys matching pattern on every node.
+func (r *RedisCache) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var mu sync.Mutex
+	var keys []string
+	err := r.forEachMaster(ctx, func(client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, pattern, cleanupBatch).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	return keys, err
+}
+
+// getKeys returns the string values of keys, nil for missing ones and those
+// of another type. Legacy keys may live in different cluster slots, so each
+// key is read with its own command in a pipeline rather than with MGET; the
+// same goes for deleteKeys.
+func (r *RedisCache) getKeys(ctx context.Context, keys []string) ([]*string, error) {
+	values := make([]*string, len(keys))
+	for start := 0; start < len(keys); start += cleanupBatch {
+		batch := keys[start:min(start+cleanupBatch, len(keys))]
+		cmds := make([]*redis.StringCmd, len(batch))
+		// Per-key errors are checked below.
+		r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range batch {
+				cmds[i] = pipe.Get(ctx, key)
+			}
+			return nil
+		})
+		for i, cmd := range cmds {
+			val, err := cmd.Result()
+			switch {
+			case err == nil:
+				values[start+i] = &val
+			case err != redis.Nil && !strings.HasPrefix(err.Error(), "WRONGTYPE"):
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// deleteKeys deletes keys, or with dryRun only counts the existing ones.
+func (r *RedisCache) deleteKeys(ctx context.Context, report *CleanupReport, keys []string, dryRun bool) error {
+	for start := 0; start < len(keys); start += cleanupBatch {
+		batch := keys[start:min(start+cleanupBatch, len(keys))]
+		cmds := make([]*redis.IntCmd, len(batch))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range batch {
+				if dryRun {
+					cmds[i] = pipe.Exists(ctx, key)
+				} else {
+					cmds[i] = pipe.Del(ctx, key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			report.Deleted += int(cmd.Val())
+		}
+	}
+	return nil
+}
+
+// escapePattern quotes the glob metacharacters of a SCAN pattern.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentAccess hammers each backend with the calls of concurrent
+// requests; run it with -race. Every worker owns some tasks and checks that
+// it reads back exactly what it last wrote, or a miss. All workers also
+// write a few shared tasks, which must never be read torn.
+func TestConcurrentAccess(t *testing.T) {
+	for _, b := range backends {
+		testConcurrentAccess(t, b)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, b backend) {
+	const (
+		workers = 16
+		ops     = 300
+		owned   = 8
+		shared  = 4
+	)
+	t.Run(b.name, func(t *testing.T) {
+		// Fewer entries than tasks, so writes race with evictions.
+		c := b.connect(t, testConfig(workers*owned/2))
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				rnd := rand.New(rand.NewSource(int64(w)))
+				// versions holds the last version written of each owned
+				// task, 0 once deleted; marked whether it was then marked
+				// missing.
+				versions := make([]int, owned)
+				marked := make([]bool, owned)
+				for i := 0; i < ops; i++ {
+					k := rnd.Intn(owned)
+					id := fmt.Sprintf("w%d-%d", w, k)
+					version := i + 1
+					switch op := rnd.Intn(10); {
+					case op < 2:
+						check(t, c.AddTask(ctx, testTask(id, version)))
+						versions[k], marked[k] = version, false
+					case op < 4:
+						check(t, c.UpdateTask(ctx, testTask(id, version)))
+						versions[k], marked[k] = version, false
+					case op < 5:
+						check(t, c.DeleteTask(ctx, id))
+						versions[k] = 0
+					case op < 6 && versions[k] == 0:
+						// Requests fill or mark tasks after reading the
+						// database, which had nothing newer here.
+						if marked[k] {
+							break
+						}
+						if rnd.Intn(2) == 0 {
+							check(t, c.MarkMissing(ctx, id))
+							marked[k] = true
+						} else {
+							check(t, c.FillTask(ctx, testTask(id, version)))
+							versions[k] = version
+						}
+					case op < 8:
+						task, err := c.GetTask(ctx, id)
+						switch {
+						case errors.Is(err, ErrNotFound):
+							if !marked[k] {
+								t.Errorf("GetTask(%s) = not found after writing version %d", id, versions[k])
+							}
+						case err == nil:
+							if want := testTask(id, versions[k]); versions[k] == 0 || !sameTask(task, want) {
+								t.Errorf("GetTask(%s) = %q, want version %d", id, task.Title, versions[k])
+							}
+						default:
+							checkMiss(t, err)
+						}
+					case op < 9:
+						id := fmt.Sprintf("shared-%d", rnd.Intn(shared))
+						check(t, c.UpdateTask(ctx, testTask(id, version)))
+						task, err := c.GetTask(ctx, id)
+						if err == nil {
+							checkIntact(t, task)
+						} else {
+							checkMiss(t, err)
+						}
+					default:
+						checkListing(t, c, ctx, rnd)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+	})
+}
+
+// checkListing reads a listing, or rebuilds the cache around it, checking
+// that every listed task is intact.
+func checkListing(t *testing.T, c Cache, ctx context.Context, rnd *rand.Rand) {
+	var tasks []Task
+	var err error
+	switch rnd.Intn(3) {
+	case 0:
+		tasks, err = c.GetPaginatedTasks(ctx, 1, 10)
+	case 1:
+		tasks, err = c.GetFilteredTasks(ctx, ListQuery{Priority: []int{1, 2}, SortBy: SortUpdatedAt, Page: 1, PageSize: 10})
+	default:
+		token, err := c.BeginRebuild(ctx)
+		check(t, err)
+		_, err = c.CompleteRebuild(ctx, token)
+		check(t, err)
+		_, err = c.IsComplete(ctx)
+		check(t, err)
+		return
+	}
+	if err != nil {
+		checkMiss(t, err)
+		return
+	}
+	for _, task := range tasks {
+		checkIntact(t, task)
+	}
+}
+
+// testTask returns version v of the task with id, or its first version for
+// 0. Its title and description both name the version.
+func testTask(id string, v int) Task {
+	at := time.Unix(1700000000, 0).UTC().Add(time.Duration(v) * time.Second)
+	return Task{
+		ID:          id,
+		Title:       fmt.Sprintf("%s:%d", id, v),
+		Description: fmt.Sprintf("%s:%d", id, v),
+		Status:      "todo",
+		Priority:    v % 3,
+		CreatedAt:   at,
+		UpdatedAt:   at,
+	}
+}
+
+func sameTask(a, b Task) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Description == b.Description && a.Status == b.Status &&
+		a.Priority == b.Priority && a.CreatedAt.Equal(b.CreatedAt) && a.UpdatedAt.Equal(b.UpdatedAt)
+}
+
+// checkIntact fails unless task is one version of a testTask, rather than
+// pieces of several.
+func checkIntact(t *testing.T, task Task) {
+	t.Helper()
+	var v int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(task.Title, task.ID+":"), "%d", &v); err != nil ||
+		!sameTask(task, testTask(task.ID, v)) {
+		t.Errorf("read torn task %+v", task)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// checkMiss fails unless err reports that the cache can't answer.
+func checkMiss(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
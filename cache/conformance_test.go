@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConformance runs the same cases against MemoryCache and RedisCache, so
+// tests and single-instance deployments see the cache production sees.
+func TestConformance(t *testing.T) {
+	for _, b := range backends {
+		if b.name != "tiered" {
+			testConformance(t, b)
+		}
+	}
+}
+
+// conformanceCase runs against a cache connected with policy and max
+// entries. Cases that evict rely on the exact limit, which sharded caches
+// only keep approximately; they are skipped there.
+type conformanceCase struct {
+	name   string
+	policy string
+	max    int
+	evicts bool
+	test   func(t *testing.T, ctx context.Context, c Cache)
+}
+
+var conformanceCases = []conformanceCase{
+	{"miss", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		wantMiss(t, ctx, c, "a")
+	}},
+	{"add and get", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1))
+		wantTask(t, ctx, c, testTask("a", 1))
+	}},
+	{"update replaces", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1))
+		task := testTask("a", 2)
+		task.Status = "done"
+		if err := c.UpdateTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+		wantTask(t, ctx, c, task)
+		complete(t, ctx, c)
+		wantIDs(t, ctx, c, ListQuery{Status: []string{"todo"}, SortBy: SortCreatedAt, Page: 1, PageSize: 10})
+		wantIDs(t, ctx, c, ListQuery{Status: []string{"done"}, SortBy: SortCreatedAt, Page: 1, PageSize: 10}, "a")
+	}},
+	{"delete", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 2))
+		if err := c.DeleteTask(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		wantMiss(t, ctx, c, "a")
+		wantPage(t, ctx, c, 1, 10, "b")
+	}},
+	{"delete several", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 2), testTask("c", 3))
+		if err := c.DeleteTasks(ctx, []string{"a", "c", "d"}); err != nil {
+			t.Fatal(err)
+		}
+		wantMiss(t, ctx, c, "a")
+		wantMiss(t, ctx, c, "c")
+		wantPage(t, ctx, c, 1, 10, "b")
+	}},
+	{"clear", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 2))
+		if err := c.MarkMissing(ctx, "c"); err != nil {
+			t.Fatal(err)
+		}
+		complete(t, ctx, c)
+		if err := c.Clear(ctx); err != nil {
+			t.Fatal(err)
+		}
+		wantMiss(t, ctx, c, "a")
+		wantMiss(t, ctx, c, "c")
+		wantPage(t, ctx, c, 1, 10)
+		wantComplete(t, ctx, c, false)
+
+		// The cache works as before.
+		add(t, ctx, c, testTask("d", 1))
+		wantTask(t, ctx, c, testTask("d", 1))
+		wantPage(t, ctx, c, 1, 10, "d")
+	}},
+	{"missing mark", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		if err := c.MarkMissing(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetTask(ctx, "a"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetTask of a missing task = %v, want ErrNotFound", err)
+		}
+		add(t, ctx, c, testTask("a", 1))
+		wantTask(t, ctx, c, testTask("a", 1))
+
+		// A cached task isn't marked.
+		if err := c.MarkMissing(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		wantTask(t, ctx, c, testTask("a", 1))
+	}},
+	{"fill keeps cached task", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 2))
+		if err := c.FillTask(ctx, testTask("a", 1)); err != nil {
+			t.Fatal(err)
+		}
+		wantTask(t, ctx, c, testTask("a", 2))
+
+		if err := c.MarkMissing(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.FillTask(ctx, testTask("b", 1)); err != nil {
+			t.Fatal(err)
+		}
+		wantTask(t, ctx, c, testTask("b", 1))
+	}},
+	{"pages by creation", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("c", 1), testTask("a", 3), testTask("b", 1), testTask("d", 2))
+		wantPage(t, ctx, c, 1, 3, "b", "c", "d")
+		wantPage(t, ctx, c, 2, 3, "a")
+		wantPage(t, ctx, c, 3, 3)
+	}},
+	{"filtered listings need a complete cache", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1))
+		q := ListQuery{SortBy: SortCreatedAt, Page: 1, PageSize: 10}
+		if _, err := c.GetFilteredTasks(ctx, q); !errors.Is(err, ErrNotCached) {
+			t.Fatalf("GetFilteredTasks of an incomplete cache = %v, want ErrNotCached", err)
+		}
+		complete(t, ctx, c)
+		wantIDs(t, ctx, c, q, "a")
+		q.SortBy = "title"
+		if _, err := c.GetFilteredTasks(ctx, q); !errors.Is(err, ErrNotCached) {
+			t.Fatalf("GetFilteredTasks sorted by an unindexed field = %v, want ErrNotCached", err)
+		}
+	}},
+	{"filtered listings", EvictionLRU, 20, false, func(t *testing.T, ctx context.Context, c Cache) {
+		// Priorities are v%3: a, d and g share 1, b and e 2, c and f 0.
+		for i, id := range []string{"g", "f", "e", "d", "c", "b", "a"} {
+			task := testTask(id, 7-i)
+			if id == "b" || id == "c" {
+				task.Status = "done"
+			}
+			task.UpdatedAt = testTask(id, i%2).UpdatedAt
+			add(t, ctx, c, task)
+		}
+		complete(t, ctx, c)
+		for _, tc := range []struct {
+			q    ListQuery
+			want []string
+		}{
+			{ListQuery{SortBy: SortCreatedAt, Page: 1, PageSize: 10}, []string{"a", "b", "c", "d", "e", "f", "g"}},
+			{ListQuery{SortBy: SortCreatedAt, Desc: true, Page: 1, PageSize: 3}, []string{"g", "f", "e"}},
+			{ListQuery{SortBy: SortCreatedAt, Desc: true, Page: 3, PageSize: 3}, []string{"a"}},
+			// Ties are ordered by ID in both directions.
+			{ListQuery{SortBy: SortUpdatedAt, Page: 1, PageSize: 10}, []string{"a", "c", "e", "g", "b", "d", "f"}},
+			{ListQuery{SortBy: SortUpdatedAt, Desc: true, Page: 1, PageSize: 10}, []string{"b", "d", "f", "a", "c", "e", "g"}},
+			{ListQuery{SortBy: SortPriority, Desc: true, Page: 1, PageSize: 10}, []string{"b", "e", "a", "d", "g", "c", "f"}},
+			{ListQuery{Status: []string{"done"}, SortBy: SortCreatedAt, Page: 1, PageSize: 10}, []string{"b", "c"}},
+			{ListQuery{Priority: []int{0, 2}, SortBy: SortCreatedAt, Page: 1, PageSize: 10}, []string{"b", "c", "e", "f"}},
+			{ListQuery{Status: []string{"todo"}, Priority: []int{1}, SortBy: SortPriority, Page: 1, PageSize: 2}, []string{"a", "d"}},
+			{ListQuery{Status: []string{"todo"}, Priority: []int{1}, SortBy: SortPriority, Page: 2, PageSize: 2}, []string{"g"}},
+			{ListQuery{Status: []string{"blocked", "done"}, Priority: []int{0}, SortBy: SortUpdatedAt, Page: 1, PageSize: 10}, []string{"c"}},
+			{ListQuery{Status: []string{"blocked"}, SortBy: SortCreatedAt, Page: 1, PageSize: 10}, nil},
+		} {
+			wantIDs(t, ctx, c, tc.q, tc.want...)
+		}
+	}},
+	{"lru eviction", EvictionLRU, 3, true, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 1), testTask("c", 1))
+		complete(t, ctx, c)
+		wantTask(t, ctx, c, testTask("a", 1))
+		tick()
+		add(t, ctx, c, testTask("d", 1))
+		wantMiss(t, ctx, c, "b")
+		for _, id := range []string{"a", "c", "d"} {
+			wantTask(t, ctx, c, testTask(id, 1))
+		}
+		wantComplete(t, ctx, c, false)
+	}},
+	{"lfu eviction", EvictionLFU, 3, true, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 1), testTask("c", 1))
+		wantTask(t, ctx, c, testTask("a", 1))
+		wantTask(t, ctx, c, testTask("a", 1))
+		wantTask(t, ctx, c, testTask("c", 1))
+		// d starts as used as the least used task, b, which goes first
+		// since its ID is lower.
+		add(t, ctx, c, testTask("d", 1))
+		wantMiss(t, ctx, c, "b")
+		add(t, ctx, c, testTask("e", 1))
+		wantMiss(t, ctx, c, "d")
+		for _, id := range []string{"a", "c", "e"} {
+			wantTask(t, ctx, c, testTask(id, 1))
+		}
+	}},
+	{"rebuild", EvictionLRU, 2, true, func(t *testing.T, ctx context.Context, c Cache) {
+		wantComplete(t, ctx, c, false)
+		token, err := c.BeginRebuild(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := c.CompleteRebuild(ctx, "other"); err != nil || ok {
+			t.Fatalf("CompleteRebuild with another token = %v, %v, want false", ok, err)
+		}
+		add(t, ctx, c, testTask("a", 1))
+		if ok, err := c.CompleteRebuild(ctx, token); err != nil || !ok {
+			t.Fatalf("CompleteRebuild = %v, %v, want true", ok, err)
+		}
+		wantComplete(t, ctx, c, true)
+
+		// An eviction during a rebuild leaves the cache incomplete.
+		token, err = c.BeginRebuild(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(t, ctx, c, testTask("b", 1), testTask("c", 1))
+		if ok, err := c.CompleteRebuild(ctx, token); err != nil || ok {
+			t.Fatalf("CompleteRebuild after an eviction = %v, %v, want false", ok, err)
+		}
+		wantComplete(t, ctx, c, false)
+	}},
+	{"write batch", EvictionLRU, 10, false, func(t *testing.T, ctx context.Context, c Cache) {
+		add(t, ctx, c, testTask("a", 1), testTask("b", 1))
+		if err := c.WriteBatch(ctx, []Task{testTask("a", 2), testTask("c", 1)}, []string{"b", "d"}); err != nil {
+			t.Fatal(err)
+		}
+		wantTask(t, ctx, c, testTask("a", 2))
+		wantTask(t, ctx, c, testTask("c", 1))
+		for _, id := range []string{"b", "d"} {
+			if _, err := c.GetTask(ctx, id); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("GetTask of deleted task %s = %v, want ErrNotFound", id, err)
+			}
+		}
+	}},
+}
+
+func testConformance(t *testing.T, b backend) {
+	for _, tc := range conformanceCases {
+		t.Run(b.name+"/"+tc.name, func(t *testing.T) {
+			cfg := testConfig(tc.max)
+			cfg.EvictionPolicy = tc.policy
+			if b.shards > 1 {
+				if tc.evicts {
+					t.Skip("eviction is per shard")
+				}
+				// Keep the limit of every shard, so nothing is evicted.
+				cfg.MaxEntries *= b.shards
+			}
+			tc.test(t, context.Background(), b.connect(t, cfg))
+		})
+	}
+}
+
+// TestShardedListings checks that listings merged from several shards page
+// like the memory cache, whose single index orders every task.
+func TestShardedListings(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig(100)
+	memory := backends[0].connect(t, cfg)
+	var sharded Cache
+	for _, b := range backends {
+		if b.name == "redis-sharded" {
+			sharded = b.connect(t, cfg)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		// Few distinct scores, so ties span shards.
+		task := testTask(fmt.Sprintf("t%02d", i), i%7)
+		task.Status = []string{"todo", "done"}[i%2]
+		for _, c := range []Cache{memory, sharded} {
+			if err := c.AddTask(ctx, task); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	complete(t, ctx, memory)
+	complete(t, ctx, sharded)
+
+	for _, sortBy := range []string{SortCreatedAt, SortUpdatedAt, SortPriority} {
+		for _, desc := range []bool{false, true} {
+			for page := 1; page <= 5; page++ {
+				for _, status := range [][]string{nil, {"done"}} {
+					q := ListQuery{Status: status, SortBy: sortBy, Desc: desc, Page: page, PageSize: 9}
+					want, err := memory.GetFilteredTasks(ctx, q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantIDs(t, ctx, sharded, q, strings.Fields(strings.Trim(ids(want), "[]"))...)
+				}
+			}
+		}
+	}
+	for page := 1; page <= 5; page++ {
+		want, err := memory.GetPaginatedTasks(ctx, page, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantPage(t, ctx, sharded, page, 9, strings.Fields(strings.Trim(ids(want), "[]"))...)
+	}
+}
+
+// tick lets the clock move on, since RedisCache records accesses in
+// milliseconds.
+func tick() {
+	time.Sleep(2 * time.Millisecond)
+}
+
+func add(t *testing.T, ctx context.Context, c Cache, tasks ...Task) {
+	t.Helper()
+	for _, task := range tasks {
+		tick()
+		if err := c.AddTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func complete(t *testing.T, ctx context.Context, c Cache) {
+	t.Helper()
+	token, err := c.BeginRebuild(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.CompleteRebuild(ctx, token); err != nil || !ok {
+		t.Fatalf("CompleteRebuild = %v, %v, want true", ok, err)
+	}
+}
+
+func wantComplete(t *testing.T, ctx context.Context, c Cache, want bool) {
+	t.Helper()
+	if ok, err := c.IsComplete(ctx); err != nil || ok != want {
+		t.Fatalf("IsComplete = %v, %v, want %v", ok, err, want)
+	}
+}
+
+func wantTask(t *testing.T, ctx context.Context, c Cache, want Task) {
+	t.Helper()
+	tick()
+	task, err := c.GetTask(ctx, want.ID)
+	if err != nil {
+		t.Fatalf("GetTask(%s): %v", want.ID, err)
+	}
+	if !sameTask(task, want) {
+		t.Fatalf("GetTask(%s) = %+v, want %+v", want.ID, task, want)
+	}
+}
+
+func wantMiss(t *testing.T, ctx context.Context, c Cache, id string) {
+	t.Helper()
+	if task, err := c.GetTask(ctx, id); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("GetTask(%s) = %+v, %v, want ErrNotCached", id, task, err)
+	}
+}
+
+func wantPage(t *testing.T, ctx context.Context, c Cache, page, pageSize int, want ...string) {
+	t.Helper()
+	tasks, err := c.GetPaginatedTasks(ctx, page, pageSize)
+	if err != nil {
+		t.Fatalf("GetPaginatedTasks(%d, %d): %v", page, pageSize, err)
+	}
+	if got := ids(tasks); got != fmt.Sprint(want) {
+		t.Fatalf("GetPaginatedTasks(%d, %d) = %s, want %v", page, pageSize, got, want)
+	}
+}
+
+func wantIDs(t *testing.T, ctx context.Context, c Cache, q ListQuery, want ...string) {
+	t.Helper()
+	tasks, err := c.GetFilteredTasks(ctx, q)
+	if err != nil {
+		t.Fatalf("GetFilteredTasks(%+v): %v", q, err)
+	}
+	if got := ids(tasks); got != fmt.Sprint(want) {
+		t.Fatalf("GetFilteredTasks(%+v) = %s, want %v", q, got, want)
+	}
+}
+
+func ids(tasks []Task) string {
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	return fmt.Sprint(ids)
+}
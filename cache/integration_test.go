@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/task-microservice/config"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestIntegration runs the conformance and concurrency cases against real
+// Redis deployments, such as those of docker-compose.redis-ha.yaml (see
+// redis-ha-test.sh). It is skipped unless REDIS_CLUSTER_ADDRS, or
+// REDIS_SENTINEL_ADDRS and REDIS_MASTER_NAME, name comma separated nodes.
+func TestIntegration(t *testing.T) {
+	var deployments []backend
+	if addrs := os.Getenv("REDIS_CLUSTER_ADDRS"); addrs != "" {
+		deployments = append(deployments, deployment("cluster", DefaultClusterShards, config.RedisConfig{
+			Mode:  ModeCluster,
+			Addrs: strings.Split(addrs, ","),
+		}))
+	}
+	if addrs := os.Getenv("REDIS_SENTINEL_ADDRS"); addrs != "" {
+		deployments = append(deployments, deployment("sentinel", 1, config.RedisConfig{
+			Mode:       ModeSentinel,
+			Addrs:      strings.Split(addrs, ","),
+			MasterName: os.Getenv("REDIS_MASTER_NAME"),
+		}))
+	}
+	if len(deployments) == 0 {
+		t.Skip("set REDIS_CLUSTER_ADDRS or REDIS_SENTINEL_ADDRS to test against real Redis deployments")
+	}
+
+	for _, b := range deployments {
+		testConformance(t, b)
+		testConcurrentAccess(t, b)
+		if b.name == "cluster" {
+			t.Run("cluster/shards spread over masters", func(t *testing.T) {
+				testShardsSpread(t, b)
+			})
+		}
+	}
+}
+
+// integrationPrefixes numbers the key prefixes of integration tests, so
+// tests sharing a deployment don't see each other's keys.
+var integrationPrefixes atomic.Int64
+
+// deployment returns a backend connecting to the Redis deployment of base,
+// in a key prefix of its own that is deleted when the test ends.
+func deployment(name string, shards int, base config.RedisConfig) backend {
+	return backend{name, shards, func(t testing.TB, cfg config.RedisConfig) Cache {
+		cfg.Mode, cfg.Addrs, cfg.MasterName = base.Mode, base.Addrs, base.MasterName
+		cfg.KeyPrefix = fmt.Sprintf("test-%d-%d", time.Now().UnixNano(), integrationPrefixes.Add(1))
+		r := NewRedisCache(cfg)
+		c := connect(t, r)
+		t.Cleanup(func() {
+			ctx := context.Background()
+			keys, err := r.scanKeys(ctx, escapePattern("{"+cfg.KeyPrefix+":")+"*")
+			if err == nil {
+				err = r.deleteKeys(ctx, &CleanupReport{}, keys, false)
+			}
+			if err != nil {
+				t.Errorf("failed to delete test keys: %v", err)
+			}
+		})
+		return c
+	}}
+}
+
+// testShardsSpread checks that the tasks of a cluster cache don't all land
+// on one master.
+func testShardsSpread(t *testing.T, b backend) {
+	ctx := context.Background()
+	c := b.connect(t, testConfig(1000))
+	for i := 0; i < 100; i++ {
+		if err := c.AddTask(ctx, testTask(fmt.Sprintf("spread-%d", i), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := c.(*RedisCache)
+	var mu sync.Mutex
+	masters := 0
+	err := r.forEachMaster(ctx, func(client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, escapePattern("{"+r.keys.prefix+":")+"*", cleanupBatch).Iterator()
+		if iter.Next(ctx) {
+			mu.Lock()
+			masters++
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masters < 2 {
+		t.Fatalf("cache keys are on %d master(s), want them spread over several", masters)
+	}
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// invalidationChannel is the pub/sub channel, below the keyspace, on which
+// instances announce changed tasks.
+const invalidationChannel = "invalidations"
+
+// Invalidator broadcasts the IDs of changed tasks to every instance, so they
+// can drop their local copies.
+type Invalidator interface {
+	// Publish announces that the task changed, or every task for "". The
+	// publishing instance is not notified.
+	Publish(ctx context.Context, id string) error
+	// Subscribe calls evict with the ID of every task changed by another
+	// instance until Close, and with "" whenever messages may have been
+	// missed, e.g. after a reconnect, meaning every task may have changed.
+	Subscribe(evict func(id string)) error
+	Close() error
+}
+
+type invalidation struct {
+	Origin string `json:"origin"`
+	ID     string `json:"id"`
+}
+
+// RedisInvalidator is an Invalidator using Redis pub/sub on the connection
+// and keyspace of a RedisCache. Pub/sub delivery is at most once, so
+// subscribers must bound the lifetime of local entries.
+type RedisInvalidator struct {
+	client  redis.UniversalClient
+	timeout time.Duration
+	channel string
+	origin  string
+	pubsub  *redis.PubSub
+	// cancel stops the subscription started by Subscribe.
+	cancel context.CancelFunc
+}
+
+// NewRedisInvalidator returns an invalidator sharing the connection of a
+// connected RedisCache.
+func NewRedisInvalidator(r *RedisCache) *RedisInvalidator {
+	// Without randomness all instances would share the empty origin and
+	// ignore each other, so fall back to the time.
+	origin, err := randomToken()
+	if err != nil {
+		origin = fmt.Sprint(time.Now().UnixNano())
+	}
+	return &RedisInvalidator{
+		client:  r.client,
+		timeout: r.timeout,
+		channel: r.keys.name(invalidationChannel),
+		origin:  origin,
+	}
+}
+
+func (i *RedisInvalidator) Publish(ctx context.Context, id string) error {
+	msg, err := json.Marshal(invalidation{Origin: i.origin, ID: id})
+	if err != nil {
+		return err
+	}
+	if i.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.timeout)
+		defer cancel()
+	}
+	return i.client.Publish(ctx, i.channel, msg).Err()
+}
+
+func (i *RedisInvalidator) Subscribe(evict func(id string)) error {
+	// The subscription outlives any request, so it runs until Close.
+	ctx, cancel := context.WithCancel(context.Background())
+	i.pubsub = i.client.Subscribe(ctx, i.channel)
+	// Wait for the subscription, so no change published after Subscribe
+	// returns is missed.
+	if _, err := i.pubsub.Receive(ctx); err != nil {
+		cancel()
+		i.pubsub.Close()
+		return err
+	}
+	i.cancel = cancel
+
+	go func() {
+		for {
+			msg, err := i.pubsub.Receive(ctx)
+			if err == redis.ErrClosed || ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Cache invalidation subscription failed: %v", err)
+				evict("")
+				time.Sleep(time.Second)
+				continue
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				// The connection was re-established; anything published
+				// meanwhile was lost.
+				evict("")
+			case *redis.Message:
+				var inv invalidation
+				if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+					log.Printf("Ignoring malformed cache invalidation %q: %v", msg.Payload, err)
+					continue
+				}
+				if inv.Origin != i.origin {
+					evict(inv.ID)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (i *RedisInvalidator) Close() error {
+	if i.pubsub == nil {
+		return nil
+	}
+	i.cancel()
+	return i.pubsub.Close()
+}
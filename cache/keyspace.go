@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Names of the keys in a keyspace shard. Task values live under
+// taskKeyPrefix plus the task ID; the rest index the tasks of the shard.
+const (
+	taskKeyPrefix = "task:"
+	// createdKey, updatedKey and priorityKey order cached task IDs by those
+	// fields.
+	createdKey  = "index:created_at"
+	updatedKey  = "index:updated_at"
+	priorityKey = "index:priority"
+	// metaKey maps each cached task ID to its status and priority, so
+	// listings can filter by them and index entries can be cleaned up after
+	// the task key itself expired or was evicted.
+	metaKey = "meta"
+	// accessKey scores every cached task ID by last access time (lru) or
+	// access count (lfu).
+	accessKey = "access"
+	// completeKey holds completeValue while every task in the database is
+	// cached, so listings may be served from the indexes alone, or the token
+	// of a running rebuild. Any eviction from the shard removes it.
+	completeKey = "complete"
+)
+
+// shardsKey, outside the shards, records the number of shards the keyspace
+// was written with.
+const shardsKey = "shards"
+
+// Defaults for config.RedisConfig.KeyPrefix, SchemaVersion and Shards.
+const (
+	DefaultKeyPrefix     = "task-service"
+	DefaultSchemaVersion = 2
+	// DefaultClusterShards spreads the keys of a cluster over as many hash
+	// tags; other modes default to a single shard.
+	DefaultClusterShards = 16
+)
+
+// keyspace names every key of one cache namespace and schema version. Tasks
+// are spread over shards by a hash of their ID; each shard holds its tasks
+// and their indexes below its own Redis Cluster hash tag, such as
+// "{task-service:v2:7}:", so every script touches a single slot while the
+// shards spread over the cluster. Replicas configured with another version
+// never see these keys, so a format change can be rolled out by bumping the
+// version.
+type keyspace struct {
+	prefix  string
+	version int
+	shards  int
+	// base names keys and channels of the whole keyspace.
+	base string
+}
+
+func newKeyspace(prefix string, version, shards int) keyspace {
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+	if version == 0 {
+		version = DefaultSchemaVersion
+	}
+	if shards <= 0 {
+		shards = 1
+	}
+	return keyspace{prefix, version, shards, fmt.Sprintf("{%s:v%d}:", prefix, version)}
+}
+
+// name returns the name of a key or channel of the whole keyspace.
+func (k keyspace) name(name string) string {
+	return k.base + name
+}
+
+// shard returns the shard of the task with id.
+func (k keyspace) shard(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(k.shards))
+}
+
+func (k keyspace) key(shard int, name string) string {
+	return fmt.Sprintf("{%s:v%d:%d}:%s", k.prefix, k.version, shard, name)
+}
+
+func (k keyspace) task(id string) string {
+	return k.key(k.shard(id), taskKeyPrefix+id)
+}
+
+// indexKeys returns the first KEYS of the scripts run on shard, followed by
+// the task keys of ids, which must belong to the shard.
+func (k keyspace) indexKeys(shard int, ids ...string) []string {
+	keys := []string{
+		k.key(shard, createdKey),
+		k.key(shard, metaKey),
+		k.key(shard, accessKey),
+		k.key(shard, updatedKey),
+		k.key(shard, priorityKey),
+		k.key(shard, completeKey),
+	}
+	for _, id := range ids {
+		keys = append(keys, k.key(shard, taskKeyPrefix+id))
+	}
+	return keys
+}
+
+// byShard groups ids by their shard.
+func (k keyspace) byShard(ids []string) map[int][]string {
+	shards := make(map[int][]string)
+	for _, id := range ids {
+		s := k.shard(id)
+		shards[s] = append(shards[s], id)
+	}
+	return shards
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestKeyspaceHashTags checks that the keys of every script share the hash
+// tag of their shard, so each runs on one cluster slot, and that shards
+// have tags of their own.
+func TestKeyspaceHashTags(t *testing.T) {
+	k := newKeyspace("test", 2, DefaultClusterShards)
+	tags := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("task-%d", i)
+		shard := k.shard(id)
+		keys := k.indexKeys(shard, id)
+		if keys[len(keys)-1] != k.task(id) {
+			t.Fatalf("indexKeys(%d, %s) ends with %s, want %s", shard, id, keys[len(keys)-1], k.task(id))
+		}
+		tag := hashTag(keys[0])
+		for _, key := range keys {
+			if hashTag(key) != tag {
+				t.Fatalf("keys of shard %d have hash tags %s and %s", shard, tag, hashTag(key))
+			}
+		}
+		if other, ok := tags[tag]; ok && other != shard {
+			t.Fatalf("shards %d and %d share hash tag %s", other, shard, tag)
+		}
+		tags[tag] = shard
+	}
+	if len(tags) != DefaultClusterShards {
+		t.Fatalf("1000 tasks landed in %d shards, want %d", len(tags), DefaultClusterShards)
+	}
+}
+
+// hashTag returns the part of key Redis Cluster hashes.
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	end := strings.IndexByte(key[start+1:], '}')
+	return key[start+1 : start+1+end]
+}
@@ -0,0 +1,376 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/drive-deep/task-microservice/config"
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// MemoryCache is an in-process Cache for tests and single-instance
+// deployments. It follows RedisCache: the same eviction policies and settings
+// (config.RedisConfig), listings ordered like the database, negative entries,
+// and filtered listings only while a rebuild has cached every task. It is
+// safe for concurrent use.
+type MemoryCache struct {
+	cfg     config.RedisConfig
+	mu      sync.Mutex
+	maxSize int
+	policy  string
+	ttl     time.Duration
+	jitter  float64
+	negTTL  time.Duration
+	tasks   map[string]*memoryEntry
+	missing map[string]time.Time
+	// evictions and indexes hold every entry of tasks, ordered for eviction
+	// and by each sort field.
+	evictions evictionHeap
+	indexes   map[string]*sortIndex
+	// marker is completeValue while every task is cached, the token of a
+	// running rebuild, or empty; markedAt is when it was set.
+	marker   string
+	markedAt time.Time
+}
+
+type memoryEntry struct {
+	task    models.Task
+	expires time.Time // zero for none
+	// score orders entries for eviction: last access in nanoseconds under
+	// lru, access count under lfu.
+	score float64
+	// slot is the position of the entry in evictions.
+	slot int
+}
+
+// NewMemoryCache returns a cache with the eviction settings of cfg.
+func NewMemoryCache(cfg config.RedisConfig) *MemoryCache {
+	return &MemoryCache{
+		cfg:     cfg,
+		maxSize: cfg.MaxEntries,
+		tasks:   make(map[string]*memoryEntry),
+		missing: make(map[string]time.Time),
+		indexes: newSortIndexes(),
+	}
+}
+
+func (m *MemoryCache) Connect() (Cache, error) {
+	m.policy = m.cfg.EvictionPolicy
+	if m.policy == "" {
+		m.policy = EvictionLRU
+	}
+	m.ttl = m.cfg.TTL
+	m.jitter = m.cfg.TTLJitter
+	m.negTTL = m.cfg.NegativeTTL
+	if m.jitter < 0 {
+		return nil, fmt.Errorf("ttl_jitter must not be negative")
+	}
+	switch m.policy {
+	case EvictionTTL:
+		if m.ttl <= 0 {
+			return nil, fmt.Errorf("eviction policy %q requires a positive ttl", m.policy)
+		}
+	case EvictionLRU, EvictionLFU:
+		if m.maxSize <= 0 {
+			return nil, fmt.Errorf("eviction policy %q requires a positive max_entries", m.policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", m.policy)
+	}
+	return m, nil
+}
+
+func (m *MemoryCache) Close() error {
+	return nil
+}
+
+func (m *MemoryCache) AddTask(ctx context.Context, task Task) error {
+	m.write(task, false)
+	return nil
+}
+
+func (m *MemoryCache) FillTask(ctx context.Context, task Task) error {
+	m.write(task, true)
+	return nil
+}
+
+func (m *MemoryCache) MarkMissing(ctx context.Context, id string) error {
+	if m.negTTL <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.live(id, time.Now()); !ok {
+		m.missing[id] = time.Now().Add(m.negTTL)
+	}
+	return nil
+}
+
+func (m *MemoryCache) GetTask(ctx context.Context, id string) (Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	entry, ok := m.live(id, now)
+	if !ok {
+		if expires, ok := m.missing[id]; ok {
+			if now.Before(expires) {
+				return Task{}, ErrNotFound
+			}
+			delete(m.missing, id)
+		}
+		return Task{}, ErrNotCached
+	}
+	switch m.policy {
+	case EvictionLRU:
+		entry.score = float64(now.UnixNano())
+	case EvictionLFU:
+		entry.score++
+	}
+	heap.Fix(&m.evictions, entry.slot)
+	return entry.task, nil
+}
+
+func (m *MemoryCache) GetPaginatedTasks(ctx context.Context, page, pageSize int) ([]Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.page(ListQuery{SortBy: SortCreatedAt, Page: page, PageSize: pageSize}), nil
+}
+
+func (m *MemoryCache) GetFilteredTasks(ctx context.Context, q ListQuery) ([]Task, error) {
+	if _, ok := sortKeys[q.SortBy]; !ok {
+		return nil, ErrNotCached
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isComplete() {
+		return nil, ErrNotCached
+	}
+	tasks := m.page(q)
+	if m.marker == "" {
+		// Tasks expired while listing.
+		return nil, ErrNotCached
+	}
+	return tasks, nil
+}
+
+func (m *MemoryCache) BeginRebuild(ctx context.Context) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marker, m.markedAt = token, time.Now()
+	return token, nil
+}
+
+func (m *MemoryCache) CompleteRebuild(ctx context.Context, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.marker != token || m.markerExpired() {
+		return false, nil
+	}
+	m.marker = completeValue
+	return true, nil
+}
+
+func (m *MemoryCache) IsComplete(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isComplete(), nil
+}
+
+func (m *MemoryCache) isComplete() bool {
+	return m.marker == completeValue && !m.markerExpired()
+}
+
+// markerExpired reports whether the marker outlived the TTL, like the marker
+// key of RedisCache.
+func (m *MemoryCache) markerExpired() bool {
+	return m.ttl > 0 && time.Since(m.markedAt) >= m.ttl
+}
+
+func (m *MemoryCache) UpdateTask(ctx context.Context, task Task) error {
+	m.write(task, false)
+	return nil
+}
+
+func (m *MemoryCache) DeleteTask(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drop(id)
+	return nil
+}
+
+func (m *MemoryCache) DeleteTasks(ctx context.Context, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		m.drop(id)
+	}
+	return nil
+}
+
+func (m *MemoryCache) Clear(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tasks = make(map[string]*memoryEntry)
+	m.missing = make(map[string]time.Time)
+	m.evictions = nil
+	m.indexes = newSortIndexes()
+	m.marker = ""
+	return nil
+}
+
+func (m *MemoryCache) WriteBatch(ctx context.Context, tasks []Task, deleted []string) error {
+	for _, task := range tasks {
+		m.write(task, false)
+	}
+	m.mu.Lock()
+	for _, id := range deleted {
+		m.drop(id)
+	}
+	m.mu.Unlock()
+	for _, id := range deleted {
+		m.MarkMissing(ctx, id)
+	}
+	return nil
+}
+
+func (m *MemoryCache) write(task Task, fill bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if _, ok := m.live(task.ID, now); ok && fill {
+		return
+	}
+	delete(m.missing, task.ID)
+
+	entry := &memoryEntry{task: task}
+	if ttl := m.expiry(); ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	switch m.policy {
+	case EvictionLRU:
+		entry.score = float64(now.UnixNano())
+	case EvictionLFU:
+		// Like RedisCache, new entries start at the score of the least used
+		// one and updates count as an access.
+		if old, ok := m.tasks[task.ID]; ok {
+			entry.score = old.score + 1
+		} else if lowest := m.evictions.lowest(""); lowest != nil {
+			entry.score = lowest.score
+		} else {
+			entry.score = 1
+		}
+	}
+	m.drop(task.ID)
+	m.tasks[task.ID] = entry
+	heap.Push(&m.evictions, entry)
+	for _, index := range m.indexes {
+		index.insert(task)
+	}
+
+	if m.policy == EvictionTTL {
+		return
+	}
+	for len(m.tasks) > m.maxSize {
+		m.drop(m.evictions.lowest(task.ID).task.ID)
+		m.marker = ""
+	}
+}
+
+// drop removes the entry of id, if any, from tasks and every index.
+func (m *MemoryCache) drop(id string) {
+	entry, ok := m.tasks[id]
+	if !ok {
+		return
+	}
+	delete(m.tasks, id)
+	heap.Remove(&m.evictions, entry.slot)
+	for _, index := range m.indexes {
+		index.remove(entry.task)
+	}
+}
+
+// live returns the entry of id unless it is missing or expired, dropping
+// expired entries.
+func (m *MemoryCache) live(id string, now time.Time) (*memoryEntry, bool) {
+	entry, ok := m.tasks[id]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(now) {
+		m.drop(id)
+		m.marker = ""
+		return nil, false
+	}
+	return entry, true
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// page returns the live tasks matching q, ordered by the sort field and then
+// by ID. It walks the index of the sort field up to the end of the page.
+func (m *MemoryCache) page(q ListQuery) []Task {
+	start := (q.Page - 1) * q.PageSize
+	if q.PageSize <= 0 || start < 0 {
+		return nil
+	}
+	statuses := make(map[string]bool, len(q.Status))
+	for _, s := range q.Status {
+		statuses[s] = true
+	}
+	priorities := make(map[int]bool, len(q.Priority))
+	for _, p := range q.Priority {
+		priorities[p] = true
+	}
+
+	now := time.Now()
+	var tasks []Task
+	var expired []string
+	m.indexes[q.SortBy].each(q.Desc, func(id string) bool {
+		entry := m.tasks[id]
+		switch {
+		case entry.expired(now):
+			expired = append(expired, id)
+		case len(statuses) > 0 && !statuses[entry.task.Status]:
+		case len(priorities) > 0 && !priorities[entry.task.Priority]:
+		case start > 0:
+			start--
+		default:
+			tasks = append(tasks, entry.task)
+		}
+		return len(tasks) < q.PageSize
+	})
+	// Expired entries are dropped once the walk no longer needs the index.
+	for _, id := range expired {
+		m.drop(id)
+		m.marker = ""
+	}
+	return tasks
+}
+
+// sortScore matches the scores RedisCache indexes tasks by.
+func sortScore(task Task, field string) float64 {
+	switch field {
+	case SortUpdatedAt:
+		return float64(task.UpdatedAt.UnixMicro())
+	case SortPriority:
+		return float64(task.Priority)
+	}
+	return float64(task.CreatedAt.UnixMicro())
+}
+
+func (m *MemoryCache) expiry() time.Duration {
+	if m.ttl <= 0 || m.jitter <= 0 {
+		return m.ttl
+	}
+	return m.ttl + time.Duration(rand.Float64()*m.jitter*float64(m.ttl))
+}
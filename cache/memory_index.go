@@ -0,0 +1,133 @@
+package cache
+
+import "sort"
+
+// evictionHeap orders the entries of a MemoryCache for eviction like the
+// access index of RedisCache: lowest score first, ties broken by ID. It
+// implements heap.Interface; each entry knows its slot, so a changed score is
+// fixed in logarithmic time.
+type evictionHeap []*memoryEntry
+
+func (h evictionHeap) Len() int { return len(h) }
+
+func (h evictionHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score < h[j].score
+	}
+	return h[i].task.ID < h[j].task.ID
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].slot = i
+	h[j].slot = j
+}
+
+func (h *evictionHeap) Push(x interface{}) {
+	entry := x.(*memoryEntry)
+	entry.slot = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *evictionHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// lowest returns the entry to evict first other than keep, or nil. The
+// second lowest entry is one of the children of the root.
+func (h evictionHeap) lowest(keep string) *memoryEntry {
+	if len(h) == 0 {
+		return nil
+	}
+	if h[0].task.ID != keep {
+		return h[0]
+	}
+	switch {
+	case len(h) == 1:
+		return nil
+	case len(h) == 2 || h.Less(1, 2):
+		return h[1]
+	}
+	return h[2]
+}
+
+// sortIndex orders the cached tasks by one sort field and then by ID, like
+// the sorted sets RedisCache lists from, so pages are read without sorting.
+type sortIndex struct {
+	field string
+	keys  []indexKey
+}
+
+type indexKey struct {
+	score float64
+	id    string
+}
+
+func (k indexKey) less(o indexKey) bool {
+	if k.score != o.score {
+		return k.score < o.score
+	}
+	return k.id < o.id
+}
+
+func newSortIndexes() map[string]*sortIndex {
+	indexes := make(map[string]*sortIndex, len(sortKeys))
+	for field := range sortKeys {
+		indexes[field] = &sortIndex{field: field}
+	}
+	return indexes
+}
+
+func (x *sortIndex) key(task Task) indexKey {
+	return indexKey{sortScore(task, x.field), task.ID}
+}
+
+// search returns the position of k, or where it would be inserted.
+func (x *sortIndex) search(k indexKey) int {
+	return sort.Search(len(x.keys), func(i int) bool { return !x.keys[i].less(k) })
+}
+
+func (x *sortIndex) insert(task Task) {
+	k := x.key(task)
+	i := x.search(k)
+	x.keys = append(x.keys, indexKey{})
+	copy(x.keys[i+1:], x.keys[i:])
+	x.keys[i] = k
+}
+
+func (x *sortIndex) remove(task Task) {
+	k := x.key(task)
+	if i := x.search(k); i < len(x.keys) && x.keys[i] == k {
+		x.keys = append(x.keys[:i], x.keys[i+1:]...)
+	}
+}
+
+// each calls fn with the task IDs in order until it returns false. Descending
+// order reverses the scores but keeps ties ordered by ID, like the negated
+// scores of RedisCache listings.
+func (x *sortIndex) each(desc bool, fn func(id string) bool) {
+	if !desc {
+		for _, k := range x.keys {
+			if !fn(k.id) {
+				return
+			}
+		}
+		return
+	}
+	for end := len(x.keys); end > 0; {
+		start := end - 1
+		for start > 0 && x.keys[start-1].score == x.keys[end-1].score {
+			start--
+		}
+		for _, k := range x.keys[start:end] {
+			if !fn(k.id) {
+				return
+			}
+		}
+		end = start
+	}
+}
@@ -0,0 +1,31 @@
+package cache
+
+import "context"
+
+// NoopCache caches nothing, so every read goes to the database.
+type NoopCache struct{}
+
+func NewNoopCache() *NoopCache {
+	return &NoopCache{}
+}
+
+func (NoopCache) Connect() (Cache, error)                       { return NoopCache{}, nil }
+func (NoopCache) Close() error                                  { return nil }
+func (NoopCache) AddTask(context.Context, Task) error           { return nil }
+func (NoopCache) GetTask(context.Context, string) (Task, error) { return Task{}, ErrNotCached }
+func (NoopCache) FillTask(context.Context, Task) error          { return nil }
+func (NoopCache) MarkMissing(context.Context, string) error     { return nil }
+func (NoopCache) GetPaginatedTasks(context.Context, int, int) ([]Task, error) {
+	return nil, ErrNotCached
+}
+func (NoopCache) GetFilteredTasks(context.Context, ListQuery) ([]Task, error) {
+	return nil, ErrNotCached
+}
+func (NoopCache) UpdateTask(context.Context, Task) error                { return nil }
+func (NoopCache) BeginRebuild(context.Context) (string, error)          { return "", nil }
+func (NoopCache) CompleteRebuild(context.Context, string) (bool, error) { return false, nil }
+func (NoopCache) IsComplete(context.Context) (bool, error)              { return false, nil }
+func (NoopCache) DeleteTask(context.Context, string) error              { return nil }
+func (NoopCache) DeleteTasks(context.Context, []string) error           { return nil }
+func (NoopCache) Clear(context.Context) error                           { return nil }
+func (NoopCache) WriteBatch(context.Context, []Task, []string) error    { return nil }
@@ -1,10 +1,17 @@
 package cache
 
 import (
-	"container/list"
 	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/models"
@@ -12,209 +19,629 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
+// Eviction policies selectable with config.RedisConfig.EvictionPolicy.
+const (
+	EvictionTTL = "ttl"
+	EvictionLRU = "lru"
+	EvictionLFU = "lfu"
+)
+
+// RedisCache is safe for concurrent use by multiple goroutines. All eviction
+// state lives in Redis, so every replica sharing it sees the same cache.
 type RedisCache struct {
-	client  *redis.Client
-	ctx     context.Context
+	cfg    config.RedisConfig
+	client redis.UniversalClient
+	keys   keyspace
+	// maxSize bounds the entries of each shard.
 	maxSize int
-	lruList *list.List
-	lruMap  map[string]*list.Element
+	policy  string
+	ttl     time.Duration
+	jitter  float64
+	negTTL  time.Duration
+	// timeout bounds every operation, on top of the caller's context.
+	timeout time.Duration
 }
 
-type lruEntry struct {
-	key   string
-	value models.Task
+// NewRedisCache returns a cache for the Redis deployment and the eviction
+// settings of cfg.
+func NewRedisCache(cfg config.RedisConfig) *RedisCache {
+	return &RedisCache{cfg: cfg}
 }
 
-func NewRedisCache(maxSize int) *RedisCache {
-	return &RedisCache{
-		ctx:     context.Background(),
-		maxSize: maxSize,
-		lruList: list.New(),
-		lruMap:  make(map[string]*list.Element),
+func (r *RedisCache) Connect() (Cache, error) {
+	if strings.ContainsAny(r.cfg.KeyPrefix, "{}") {
+		return nil, fmt.Errorf("key_prefix must not contain braces")
+	}
+	shards := r.cfg.Shards
+	if shards == 0 && r.cfg.Mode == ModeCluster {
+		shards = DefaultClusterShards
+	}
+	if shards < 0 {
+		return nil, fmt.Errorf("shards must not be negative")
+	}
+	r.keys = newKeyspace(r.cfg.KeyPrefix, r.cfg.SchemaVersion, shards)
+	// Eviction is per shard; IDs hash evenly, so the total stays close to
+	// max_entries.
+	r.maxSize = (r.cfg.MaxEntries + r.keys.shards - 1) / r.keys.shards
+	r.policy = r.cfg.EvictionPolicy
+	if r.policy == "" {
+		r.policy = EvictionLRU
+	}
+	r.ttl = r.cfg.TTL
+	r.jitter = r.cfg.TTLJitter
+	r.negTTL = r.cfg.NegativeTTL
+	r.timeout = r.cfg.Timeout
+	if r.jitter < 0 {
+		return nil, fmt.Errorf("ttl_jitter must not be negative")
+	}
+	switch r.policy {
+	case EvictionTTL:
+		if r.ttl <= 0 {
+			return nil, fmt.Errorf("eviction policy %q requires a positive ttl", r.policy)
+		}
+	case EvictionLRU, EvictionLFU:
+		if r.maxSize <= 0 {
+			return nil, fmt.Errorf("eviction policy %q requires a positive max_entries", r.policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", r.policy)
 	}
-}
 
-func (r *RedisCache) Connect() (Cache, error) {
-	cfg := config.GetConfig()
-	r.client = redis.NewClient(&redis.Options{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	})
+	client, err := newRedisClient(r.cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
 
-	_, err := r.client.Ping(r.ctx).Result()
+	ctx, cancel := r.withTimeout(context.Background())
+	defer cancel()
+	_, err = r.client.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
-	return r, nil
-}
+	if err := r.checkShards(ctx); err != nil {
+		return nil, err
+	}
 
-func (r *RedisCache) Close() error {
-	return r.client.Close()
+	if r.cfg.MaxMemoryPolicy != "" {
+		// Managed Redis services often disable CONFIG, in which case the
+		// policy has to be set on the server side.
+		err := r.forEachMaster(ctx, func(client redis.UniversalClient) error {
+			return client.ConfigSet(ctx, "maxmemory-policy", r.cfg.MaxMemoryPolicy).Err()
+		})
+		if err != nil {
+			log.Printf("Failed to set Redis maxmemory-policy to %s: %v", r.cfg.MaxMemoryPolicy, err)
+		}
+	}
+	return r, nil
 }
 
-func (r *RedisCache) AddTask(task models.Task) error {
-	data, err := json.Marshal(task)
-	if err != nil {
+// checkShards records the number of shards of the keyspace, or fails if
+// replicas already wrote it with another number: tasks would be looked up
+// in the wrong shard.
+func (r *RedisCache) checkShards(ctx context.Context) error {
+	key := r.keys.name(shardsKey)
+	if err := r.client.SetNX(ctx, key, r.keys.shards, 0).Err(); err != nil {
 		return err
 	}
-	if err := r.client.Set(r.ctx, task.ID, data, 0).Err(); err != nil {
+	shards, err := r.client.Get(ctx, key).Int()
+	if err != nil {
 		return err
 	}
-
-	// Add to sorted set for efficient pagination and sorting
-	if err := r.client.ZAdd(r.ctx, "tasks", &redis.Z{
-		Score:  float64(task.CreatedAt.Unix()), // Use CreatedAt as the score for sorting
-		Member: task.ID,
-	}).Err(); err != nil {
-		return err
+	if shards != r.keys.shards {
+		return fmt.Errorf("cache keyspace %s has %d shards, not %d; bump schema_version to change redis.shards",
+			key, shards, r.keys.shards)
 	}
+	return nil
+}
 
-	// Add to status set for filtering
-	if err := r.client.SAdd(r.ctx, fmt.Sprintf("tasks:status:%s", task.Status), task.ID).Err(); err != nil {
-		return err
-	}
+func (r *RedisCache) Close() error {
+	return r.client.Close()
+}
 
-	// Update LRU cache
-	if elem, exists := r.lruMap[task.ID]; exists {
-		r.lruList.MoveToFront(elem)
-		elem.Value.(*lruEntry).value = task
-	} else {
-		if r.lruList.Len() >= r.maxSize {
-			// Evict the least recently used item
-			evictElem := r.lruList.Back()
-			if evictElem != nil {
-				r.lruList.Remove(evictElem)
-				evictEntry := evictElem.Value.(*lruEntry)
-				delete(r.lruMap, evictEntry.key)
-				r.client.Del(r.ctx, evictEntry.key)
-				r.client.ZRem(r.ctx, "tasks", evictEntry.key)
-				r.client.SRem(r.ctx, fmt.Sprintf("tasks:status:%s", evictEntry.value.Status), evictEntry.key)
-			}
-		}
-		entry := &lruEntry{key: task.ID, value: task}
-		elem := r.lruList.PushFront(entry)
-		r.lruMap[task.ID] = elem
-	}
+func (r *RedisCache) AddTask(ctx context.Context, task models.Task) error {
+	return r.write(ctx, task, false)
+}
 
-	return nil
+func (r *RedisCache) FillTask(ctx context.Context, task models.Task) error {
+	return r.write(ctx, task, true)
+}
+
+func (r *RedisCache) MarkMissing(ctx context.Context, id string) error {
+	if r.negTTL <= 0 {
+		return nil
+	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.client.SetNX(ctx, r.keys.task(id), missingValue, r.negTTL).Err()
 }
 
-func (r *RedisCache) GetTask(id string) (models.Task, error) {
-	val, err := r.client.Get(r.ctx, id).Result()
+func (r *RedisCache) GetTask(ctx context.Context, id string) (models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	val, err := r.client.Get(ctx, r.keys.task(id)).Result()
+	if err == redis.Nil {
+		return models.Task{}, ErrNotCached
+	}
 	if err != nil {
 		return models.Task{}, err
 	}
+	if val == missingValue {
+		return models.Task{}, ErrNotFound
+	}
 	var task models.Task
 	if err := json.Unmarshal([]byte(val), &task); err != nil {
 		return models.Task{}, err
 	}
 
-	// Update LRU cache
-	if elem, exists := r.lruMap[id]; exists {
-		r.lruList.MoveToFront(elem)
+	if err := r.recordRead(ctx, id); err != nil {
+		return models.Task{}, err
 	}
 
 	return task, nil
 }
 
-func (r *RedisCache) GetPaginatedTasks(page, pageSize int) ([]models.Task, error) {
-	start := (page - 1) * pageSize
-	end := start + pageSize - 1
+func (r *RedisCache) GetPaginatedTasks(ctx context.Context, page, pageSize int) ([]models.Task, error) {
+	return r.list(ctx, ListQuery{SortBy: SortCreatedAt, Page: page, PageSize: pageSize}, false)
+}
 
-	ids, err := r.client.ZRange(r.ctx, "tasks", int64(start), int64(end)).Result()
-	if err != nil {
+// GetFilteredTasks only answers while the cache holds every task, since the
+// indexes can't tell which matching tasks are missing otherwise.
+func (r *RedisCache) GetFilteredTasks(ctx context.Context, q ListQuery) ([]models.Task, error) {
+	return r.list(ctx, q, true)
+}
+
+// list reads a page of tasks matching q. Each shard lists its first
+// matching tasks up to the end of the page; the page is then cut from their
+// merge and its values read shard by shard. The shards are read one script
+// each, not as one snapshot, so a task written in between may be listed
+// with stale values; the listing then reports a miss.
+func (r *RedisCache) list(ctx context.Context, q ListQuery, complete bool) ([]models.Task, error) {
+	sortKey, ok := sortKeys[q.SortBy]
+	if !ok {
+		return nil, ErrNotCached
+	}
+	start := (q.Page - 1) * q.PageSize
+	args := []interface{}{sortKey, q.Desc, start + q.PageSize, complete, len(q.Status)}
+	for _, status := range q.Status {
+		args = append(args, status)
+	}
+	args = append(args, len(q.Priority))
+	for _, priority := range q.Priority {
+		args = append(args, priority)
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	cmds := make([]*redis.Cmd, r.keys.shards)
+	err := r.pipelineScripts(ctx, []*redis.Script{listScript}, func(pipe redis.Pipeliner) {
+		for shard := range cmds {
+			cmds[shard] = listScript.EvalSha(ctx, pipe, r.keys.indexKeys(shard), args...)
+		}
+	})
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
-	var tasks []models.Task
-	for _, id := range ids {
-		val, err := r.client.Get(r.ctx, id).Result()
+	var listed []listedTask
+	for _, cmd := range cmds {
+		res, err := cmd.Result()
+		if err == redis.Nil {
+			return nil, ErrNotCached
+		}
 		if err != nil {
 			return nil, err
 		}
+		shard, err := parseListing(res)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, shard...)
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		a, b := listed[i], listed[j]
+		if a.score != b.score {
+			return (a.score < b.score) != q.Desc
+		}
+		return a.id < b.id
+	})
+	if start >= len(listed) {
+		return nil, nil
+	}
+	listed = listed[start:min(start+q.PageSize, len(listed))]
+
+	ids := make([]string, len(listed))
+	for i, l := range listed {
+		ids[i] = l.id
+	}
+	values, err := r.getTasks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]bool, len(q.Status))
+	for _, status := range q.Status {
+		statuses[status] = true
+	}
+	priorities := make(map[int]bool, len(q.Priority))
+	for _, priority := range q.Priority {
+		priorities[priority] = true
+	}
+	tasks := make([]models.Task, 0, len(listed))
+	var missing []string
+	stale := false
+	for i, l := range listed {
+		// The index and the keys are read apart, so tasks may have been
+		// deleted and marked missing in between.
+		data := values[i]
+		if data == "" || data == missingValue {
+			missing = append(missing, l.id)
+			continue
+		}
 		var task models.Task
-		if err := json.Unmarshal([]byte(val), &task); err != nil {
+		if err := json.Unmarshal([]byte(data), &task); err != nil {
 			return nil, err
 		}
+		if sortScore(task, q.SortBy) != l.score ||
+			(len(statuses) > 0 && !statuses[task.Status]) ||
+			(len(priorities) > 0 && !priorities[task.Priority]) {
+			stale = true
+		}
 		tasks = append(tasks, task)
 	}
-
+	if len(missing) > 0 {
+		// The tasks expired or were evicted by Redis; drop their dangling
+		// index entries so later pages are consistent again. Reaping also
+		// clears the completeness marker, so filtered listings fall back to
+		// the database until the cache is rebuilt.
+		r.reap(ctx, missing...)
+		return nil, ErrNotCached
+	}
+	if stale {
+		return nil, ErrNotCached
+	}
 	return tasks, nil
 }
 
-func (r *RedisCache) UpdateTask(task models.Task) error {
-	data, err := json.Marshal(task)
+// listedTask is an entry of a listScript reply.
+type listedTask struct {
+	id    string
+	score float64
+}
+
+func parseListing(res interface{}) ([]listedTask, error) {
+	reply, ok := res.([]interface{})
+	if !ok || len(reply) != 2 {
+		return nil, fmt.Errorf("unexpected listing reply %T", res)
+	}
+	ids, _ := reply[0].([]interface{})
+	scores, _ := reply[1].([]interface{})
+	if len(ids) != len(scores) {
+		return nil, fmt.Errorf("unexpected listing reply with %d IDs and %d scores", len(ids), len(scores))
+	}
+	listed := make([]listedTask, len(ids))
+	for i := range ids {
+		id, _ := ids[i].(string)
+		score, err := strconv.ParseFloat(fmt.Sprint(scores[i]), 64)
+		if err != nil {
+			return nil, err
+		}
+		listed[i] = listedTask{id, score}
+	}
+	return listed, nil
+}
+
+// getTasks returns the values of the tasks with ids, "" for missing ones,
+// with one MGET per shard.
+func (r *RedisCache) getTasks(ctx context.Context, ids []string) ([]string, error) {
+	position := make(map[string]int, len(ids))
+	for i, id := range ids {
+		position[id] = i
+	}
+	groups := r.keys.byShard(ids)
+	cmds := make(map[int]*redis.SliceCmd, len(groups))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for shard, group := range groups {
+			keys := make([]string, len(group))
+			for i, id := range group {
+				keys[i] = r.keys.task(id)
+			}
+			cmds[shard] = pipe.MGet(ctx, keys...)
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := r.client.Set(r.ctx, task.ID, data, 0).Err(); err != nil {
-		return err
+	values := make([]string, len(ids))
+	for shard, cmd := range cmds {
+		for i, val := range cmd.Val() {
+			data, _ := val.(string)
+			values[position[groups[shard][i]]] = data
+		}
 	}
+	return values, nil
+}
 
-	// Update sorted set for efficient pagination and sorting
-	if err := r.client.ZAdd(r.ctx, "tasks", &redis.Z{
-		Score:  float64(task.CreatedAt.Unix()), // Use CreatedAt as the score for sorting
-		Member: task.ID,
-	}).Err(); err != nil {
-		return err
+// BeginRebuild marks every shard with the token; each shard drops it on
+// its next eviction.
+func (r *RedisCache) BeginRebuild(ctx context.Context) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
 	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	// Tasks written from now on expire no earlier than the markers, since
+	// jitter only lengthens their TTLs.
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for shard := 0; shard < r.keys.shards; shard++ {
+			pipe.Set(ctx, r.keys.key(shard, completeKey), token, r.ttl)
+		}
+		return nil
+	})
+	return token, err
+}
 
-	// Update status set for filtering
-	if err := r.client.SAdd(r.ctx, fmt.Sprintf("tasks:status:%s", task.Status), task.ID).Err(); err != nil {
-		return err
+// CompleteRebuild marks the shards that kept the token complete, and reports
+// whether all of them did.
+func (r *RedisCache) CompleteRebuild(ctx context.Context, token string) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	cmds := make([]*redis.Cmd, r.keys.shards)
+	err := r.pipelineScripts(ctx, []*redis.Script{completeRebuildScript}, func(pipe redis.Pipeliner) {
+		for shard := range cmds {
+			cmds[shard] = completeRebuildScript.EvalSha(ctx, pipe, []string{r.keys.key(shard, completeKey)}, token)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if done, _ := cmd.Bool(); !done {
+			return false, nil
+		}
 	}
+	return true, nil
+}
 
-	// Update LRU cache
-	if elem, exists := r.lruMap[task.ID]; exists {
-		r.lruList.MoveToFront(elem)
-		elem.Value.(*lruEntry).value = task
-	} else {
-		if r.lruList.Len() >= r.maxSize {
-			// Evict the least recently used item
-			evictElem := r.lruList.Back()
-			if evictElem != nil {
-				r.lruList.Remove(evictElem)
-				evictEntry := evictElem.Value.(*lruEntry)
-				delete(r.lruMap, evictEntry.key)
-				r.client.Del(r.ctx, evictEntry.key)
-				r.client.ZRem(r.ctx, "tasks", evictEntry.key)
-				r.client.SRem(r.ctx, fmt.Sprintf("tasks:status:%s", evictEntry.value.Status), evictEntry.key)
-			}
+func (r *RedisCache) IsComplete(ctx context.Context) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	cmds := make([]*redis.StringCmd, r.keys.shards)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for shard := range cmds {
+			cmds[shard] = pipe.Get(ctx, r.keys.key(shard, completeKey))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() != completeValue {
+			return false, nil
 		}
-		entry := &lruEntry{key: task.ID, value: task}
-		elem := r.lruList.PushFront(entry)
-		r.lruMap[task.ID] = elem
 	}
+	return true, nil
+}
 
-	return nil
+func (r *RedisCache) UpdateTask(ctx context.Context, task models.Task) error {
+	return r.write(ctx, task, false)
+}
+
+func (r *RedisCache) DeleteTask(ctx context.Context, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return deleteScript.Run(ctx, r.client, r.keys.indexKeys(r.keys.shard(id), id), id).Err()
+}
+
+// DeleteTasks pipelines the deletions, so they cost a single round trip per
+// node.
+func (r *RedisCache) DeleteTasks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.pipelineScripts(ctx, []*redis.Script{deleteScript}, func(pipe redis.Pipeliner) {
+		for _, id := range ids {
+			deleteScript.EvalSha(ctx, pipe, r.keys.indexKeys(r.keys.shard(id), id), id)
+		}
+	})
 }
 
-func (r *RedisCache) DeleteTask(id string) error {
-	task, err := r.GetTask(id)
+// Clear deletes every key of the keyspace's shards on every node, like
+// DropSchemaVersion, so it isn't bounded by the operation timeout. Tasks
+// written meanwhile may be left out of the indexes until they expire.
+func (r *RedisCache) Clear(ctx context.Context) error {
+	keys, err := r.scanKeys(ctx, escapePattern(fmt.Sprintf("{%s:v%d:", r.keys.prefix, r.keys.version))+"*")
 	if err != nil {
 		return err
 	}
+	return r.deleteKeys(ctx, &CleanupReport{}, keys, false)
+}
 
-	if err := r.client.Del(r.ctx, id).Err(); err != nil {
+// WriteBatch pipelines the commands of every write, so a batch costs a single
+// round trip per node, plus one to purge evicted tasks.
+func (r *RedisCache) WriteBatch(ctx context.Context, tasks []models.Task, deleted []string) error {
+	if len(tasks) == 0 && len(deleted) == 0 {
+		return nil
+	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	args := make([][]interface{}, len(tasks))
+	for i, task := range tasks {
+		var err error
+		if args[i], err = r.writeArgs(task, false); err != nil {
+			return err
+		}
+	}
+	writes := make([]*redis.Cmd, len(tasks))
+	err := r.pipelineScripts(ctx, []*redis.Script{writeScript, deleteScript}, func(pipe redis.Pipeliner) {
+		for i, task := range tasks {
+			writes[i] = writeScript.EvalSha(ctx, pipe, r.keys.indexKeys(r.keys.shard(task.ID), task.ID), args[i]...)
+		}
+		for _, id := range deleted {
+			deleteScript.EvalSha(ctx, pipe, r.keys.indexKeys(r.keys.shard(id), id), id)
+			if r.negTTL > 0 {
+				pipe.SetNX(ctx, r.keys.task(id), missingValue, r.negTTL)
+			}
+		}
+	})
+	if err != nil {
 		return err
 	}
+	var evicted []string
+	for i, cmd := range writes {
+		victims, err := cmd.StringSlice()
+		if err != nil {
+			return fmt.Errorf("caching task %s: %w", tasks[i].ID, err)
+		}
+		evicted = append(evicted, victims...)
+	}
+	return r.purge(ctx, evicted)
+}
 
-	// Remove from sorted set
-	if err := r.client.ZRem(r.ctx, "tasks", id).Err(); err != nil {
+// write stores task and maintains the sort, filter and access indexes of its
+// shard in a single atomic script, evicting tasks beyond the configured
+// size. With fill set, a task already cached is left as is.
+func (r *RedisCache) write(ctx context.Context, task models.Task, fill bool) error {
+	args, err := r.writeArgs(task, fill)
+	if err != nil {
 		return err
 	}
-
-	// Remove from status set
-	if err := r.client.SRem(r.ctx, fmt.Sprintf("tasks:status:%s", task.Status), id).Err(); err != nil {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	keys := r.keys.indexKeys(r.keys.shard(task.ID), task.ID)
+	evicted, err := writeScript.Run(ctx, r.client, keys, args...).StringSlice()
+	if err != nil {
 		return err
 	}
+	return r.purge(ctx, evicted)
+}
+
+// purge deletes the keys of evicted tasks. Evicting only unindexes them
+// within the write, which doesn't know their keys in advance.
+func (r *RedisCache) purge(ctx context.Context, evicted []string) error {
+	if len(evicted) == 0 {
+		return nil
+	}
+	return r.pipelineScripts(ctx, []*redis.Script{purgeScript}, func(pipe redis.Pipeliner) {
+		for shard, ids := range r.keys.byShard(evicted) {
+			args := make([]interface{}, len(ids))
+			for i, id := range ids {
+				args[i] = id
+			}
+			purgeScript.EvalSha(ctx, pipe, r.keys.indexKeys(shard, ids...), args...)
+		}
+	})
+}
+
+// writeArgs returns the arguments of writeScript storing task.
+func (r *RedisCache) writeArgs(task models.Task, fill bool) ([]interface{}, error) {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	// Timestamps are scored in microseconds, the precision Postgres keeps, so
+	// cached listings order like database ones.
+	return []interface{}{
+		task.ID,
+		data,
+		task.Status,
+		task.Priority,
+		task.CreatedAt.UnixMicro(),
+		task.UpdatedAt.UnixMicro(),
+		r.expiry().Milliseconds(),
+		r.policy,
+		r.maxSize,
+		time.Now().UnixMilli(),
+		fill,
+	}, nil
+}
 
-	// Update LRU cache
-	if elem, exists := r.lruMap[id]; exists {
-		r.lruList.Remove(elem)
-		delete(r.lruMap, id)
+// expiry returns the TTL of a new entry: the configured TTL lengthened by a
+// random jitter, or zero for none.
+func (r *RedisCache) expiry() time.Duration {
+	if r.ttl <= 0 || r.jitter <= 0 {
+		return r.ttl
 	}
+	return r.ttl + time.Duration(rand.Float64()*r.jitter*float64(r.ttl))
+}
 
+// recordRead refreshes the access index of the task's shard after a read
+// under the lru and lfu policies. Only tasks still in the index are
+// refreshed, so a read racing with an eviction doesn't resurrect an entry
+// without a key.
+func (r *RedisCache) recordRead(ctx context.Context, id string) error {
+	shard := r.keys.shard(id)
+	switch r.policy {
+	case EvictionLRU:
+		return r.client.ZAddXX(ctx, r.keys.key(shard, accessKey), &redis.Z{
+			Score:  float64(time.Now().UnixMilli()),
+			Member: id,
+		}).Err()
+	case EvictionLFU:
+		return lfuTouchScript.Run(ctx, r.client, r.keys.indexKeys(shard), id).Err()
+	}
 	return nil
 }
+
+// reap drops index entries of tasks whose keys are gone. Failures are only
+// logged since the next read retries.
+func (r *RedisCache) reap(ctx context.Context, ids ...string) {
+	err := r.pipelineScripts(ctx, []*redis.Script{reapScript}, func(pipe redis.Pipeliner) {
+		for shard, ids := range r.keys.byShard(ids) {
+			args := make([]interface{}, len(ids))
+			for i, id := range ids {
+				args[i] = id
+			}
+			reapScript.EvalSha(ctx, pipe, r.keys.indexKeys(shard, ids...), args...)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to reap expired tasks from cache indexes: %v", err)
+	}
+}
+
+// pipelineScripts runs the commands queued by fn in a pipeline after loading
+// scripts, since pipelined scripts are only sent by hash. It returns the
+// first error of the commands.
+func (r *RedisCache) pipelineScripts(ctx context.Context, scripts []*redis.Script, fn func(pipe redis.Pipeliner)) error {
+	for _, script := range scripts {
+		if err := script.Load(ctx, r.client).Err(); err != nil {
+			return err
+		}
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fn(pipe)
+		return nil
+	})
+	return err
+}
+
+// withTimeout bounds ctx by the configured operation timeout, if any.
+func (r *RedisCache) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// sortKeys maps the fields cached listings can be sorted by to the position of
+// their index in keyspace.indexKeys, as expected by listScript.
+var sortKeys = map[string]int{
+	SortCreatedAt: 1,
+	SortUpdatedAt: 4,
+	SortPriority:  5,
+}
+
+// randomToken returns a random hex string naming a rebuild.
+func randomToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,362 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drive-deep/task-microservice/resilience"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxPendingInvalidations bounds the tasks remembered for invalidation while
+// writes are skipped. Beyond it the whole cache is cleared instead.
+const maxPendingInvalidations = 10000
+
+// invalidationBatch is the number of tasks deleted per call when invalidating
+// the tasks of skipped writes.
+const invalidationBatch = 500
+
+// ResilientCache protects a cache with a circuit breaker, so requests don't
+// wait on a cache that is down; failed reads are served by the database. In
+// degraded mode failed writes are logged and skipped rather than failing
+// requests whose database write succeeded. The tasks they concerned may be
+// stale in the cache, so reads bypass it until it works again and they are
+// invalidated, and the cache stops being complete. The service relies on it
+// to decide which cache errors fail requests, so every backend is wrapped in
+// one.
+type ResilientCache struct {
+	next     Cache
+	breaker  *resilience.Breaker
+	degraded bool
+	metrics  *expvar.Map
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	// overflowed is set when more tasks went stale than pending holds, so
+	// the whole cache is cleared instead.
+	overflowed bool
+	// stale is set while writes were skipped that the cache hasn't been
+	// invalidated for, and flushing while that is under way.
+	stale    bool
+	flushing bool
+}
+
+// NewResilientCache returns a cache wrapping the connected cache next,
+// reported as the dependency name. Its breaker opens after failureThreshold
+// consecutive failures, for openTimeout; with degraded set failed writes are
+// skipped.
+func NewResilientCache(next Cache, name string, failureThreshold int, openTimeout time.Duration, degraded bool) *ResilientCache {
+	c := &ResilientCache{
+		next:     next,
+		breaker:  resilience.NewBreaker(name, failureThreshold, openTimeout, unavailable),
+		degraded: degraded,
+		metrics:  resilience.Metrics(name),
+		pending:  make(map[string]struct{}),
+	}
+	c.metrics.Set("pending_invalidations", expvar.Func(func() interface{} {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.pending)
+	}))
+	return c
+}
+
+func (c *ResilientCache) Connect() (Cache, error) {
+	return c, nil
+}
+
+func (c *ResilientCache) Close() error {
+	return c.next.Close()
+}
+
+func (c *ResilientCache) AddTask(ctx context.Context, task Task) error {
+	return c.write(ctx, "add", []string{task.ID}, func() error { return c.next.AddTask(ctx, task) })
+}
+
+func (c *ResilientCache) GetTask(ctx context.Context, id string) (Task, error) {
+	var task Task
+	err := c.read(ctx, func() error {
+		var err error
+		task, err = c.next.GetTask(ctx, id)
+		return err
+	})
+	return task, err
+}
+
+// FillTask leaves nothing stale when skipped, since the cache didn't have
+// the task.
+func (c *ResilientCache) FillTask(ctx context.Context, task Task) error {
+	return c.write(ctx, "fill", nil, func() error { return c.next.FillTask(ctx, task) })
+}
+
+func (c *ResilientCache) MarkMissing(ctx context.Context, id string) error {
+	return c.write(ctx, "missing mark", nil, func() error { return c.next.MarkMissing(ctx, id) })
+}
+
+func (c *ResilientCache) GetPaginatedTasks(ctx context.Context, page, pageSize int) ([]Task, error) {
+	var tasks []Task
+	err := c.read(ctx, func() error {
+		var err error
+		tasks, err = c.next.GetPaginatedTasks(ctx, page, pageSize)
+		return err
+	})
+	return tasks, err
+}
+
+func (c *ResilientCache) GetFilteredTasks(ctx context.Context, q ListQuery) ([]Task, error) {
+	var tasks []Task
+	err := c.read(ctx, func() error {
+		var err error
+		tasks, err = c.next.GetFilteredTasks(ctx, q)
+		return err
+	})
+	return tasks, err
+}
+
+func (c *ResilientCache) UpdateTask(ctx context.Context, task Task) error {
+	return c.write(ctx, "update", []string{task.ID}, func() error { return c.next.UpdateTask(ctx, task) })
+}
+
+func (c *ResilientCache) DeleteTask(ctx context.Context, id string) error {
+	return c.write(ctx, "delete", []string{id}, func() error { return c.next.DeleteTask(ctx, id) })
+}
+
+func (c *ResilientCache) DeleteTasks(ctx context.Context, ids []string) error {
+	return c.write(ctx, "delete", ids, func() error { return c.next.DeleteTasks(ctx, ids) })
+}
+
+// Clear leaves the whole cache to be cleared once it works again when
+// skipped.
+func (c *ResilientCache) Clear(ctx context.Context) error {
+	err := c.do(ctx, func() error { return c.next.Clear(ctx) })
+	if !c.degraded || !down(err) {
+		return err
+	}
+	c.skipped("clear", 0, err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stale, c.overflowed = true, true
+	c.pending = make(map[string]struct{})
+	return nil
+}
+
+func (c *ResilientCache) WriteBatch(ctx context.Context, tasks []Task, deleted []string) error {
+	ids := append([]string(nil), deleted...)
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return c.write(ctx, "batch write", ids, func() error { return c.next.WriteBatch(ctx, tasks, deleted) })
+}
+
+func (c *ResilientCache) BeginRebuild(ctx context.Context) (string, error) {
+	var token string
+	err := c.do(ctx, func() error {
+		var err error
+		token, err = c.next.BeginRebuild(ctx)
+		return err
+	})
+	return token, err
+}
+
+// CompleteRebuild doesn't mark the cache complete while it may hold stale
+// tasks.
+func (c *ResilientCache) CompleteRebuild(ctx context.Context, token string) (bool, error) {
+	var ok bool
+	err := c.read(ctx, func() error {
+		var err error
+		ok, err = c.next.CompleteRebuild(ctx, token)
+		return err
+	})
+	if errors.Is(err, ErrNotCached) {
+		return false, nil
+	}
+	return ok, err
+}
+
+func (c *ResilientCache) IsComplete(ctx context.Context) (bool, error) {
+	var ok bool
+	err := c.read(ctx, func() error {
+		var err error
+		ok, err = c.next.IsComplete(ctx)
+		return err
+	})
+	if errors.Is(err, ErrNotCached) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// do calls fn through the breaker. Once the cache answers again, the tasks
+// of skipped writes are invalidated.
+func (c *ResilientCache) do(ctx context.Context, fn func() error) error {
+	err := c.breaker.Do(ctx, fn)
+	if !down(err) {
+		c.recovered()
+	}
+	return err
+}
+
+// read calls fn like do, unless the cache may hold stale tasks: then it
+// returns ErrNotCached, so the read goes to the database, and only probes
+// whether the cache works again.
+func (c *ResilientCache) read(ctx context.Context, fn func() error) error {
+	c.mu.Lock()
+	stale, flushing := c.stale, c.flushing
+	c.mu.Unlock()
+	switch {
+	case flushing:
+		return ErrNotCached
+	case stale:
+		c.do(ctx, func() error {
+			_, err := c.next.IsComplete(ctx)
+			return err
+		})
+		return ErrNotCached
+	}
+	return c.do(ctx, fn)
+}
+
+// write calls fn like do. In degraded mode a failure due to the cache being
+// unavailable is skipped, and the tasks with ids are invalidated later.
+func (c *ResilientCache) write(ctx context.Context, op string, ids []string, fn func() error) error {
+	err := c.do(ctx, fn)
+	if !c.degraded || !down(err) {
+		return err
+	}
+	c.skipped(op, len(ids), err)
+	c.invalidateLater(ids)
+	return nil
+}
+
+// skipped counts and logs a skipped write of n tasks.
+func (c *ResilientCache) skipped(op string, n int, err error) {
+	c.metrics.Add("skipped_writes", 1)
+	log.Printf("Skipped cache %s of %d tasks: %v", op, n, err)
+}
+
+// invalidateLater remembers ids for invalidation once the cache works again.
+func (c *ResilientCache) invalidateLater(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stale = true
+	c.remember(ids)
+}
+
+// remember adds ids to the pending invalidations, or gives up on them for
+// clearing the cache when there are too many. c.mu must be held.
+func (c *ResilientCache) remember(ids []string) {
+	if c.overflowed {
+		return
+	}
+	for _, id := range ids {
+		c.pending[id] = struct{}{}
+	}
+	if len(c.pending) > maxPendingInvalidations {
+		c.overflowed = true
+		c.pending = make(map[string]struct{})
+		c.metrics.Add("invalidation_overflows", 1)
+	}
+}
+
+// recovered starts invalidating the tasks of skipped writes, if any and not
+// already under way.
+func (c *ResilientCache) recovered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stale || c.flushing {
+		return
+	}
+	ids := make([]string, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	all := c.overflowed
+	c.pending = make(map[string]struct{})
+	c.overflowed = false
+	c.stale = false
+	c.flushing = true
+	go c.invalidate(ids, all)
+}
+
+// invalidate marks the cache incomplete, since the skipped writes may be
+// missing from cached listings, and deletes the tasks with ids, or every
+// task with all set. Whatever it can't finish is left for the next
+// recovery.
+func (c *ResilientCache) invalidate(ids []string, all bool) {
+	ctx := context.Background()
+	count := len(ids)
+	err := c.breaker.Do(ctx, func() error {
+		// Starting a rebuild replaces the completeness marker.
+		_, err := c.next.BeginRebuild(ctx)
+		return err
+	})
+	if err == nil && all {
+		err = c.breaker.Do(ctx, func() error { return c.next.Clear(ctx) })
+	}
+	for err == nil && !all && len(ids) > 0 {
+		batch := ids[:min(invalidationBatch, len(ids))]
+		if err = c.breaker.Do(ctx, func() error { return c.next.DeleteTasks(ctx, batch) }); err == nil {
+			ids = ids[len(batch):]
+		}
+	}
+
+	c.mu.Lock()
+	c.flushing = false
+	if err != nil {
+		c.stale = true
+		if all {
+			c.overflowed = true
+			c.pending = make(map[string]struct{})
+		} else {
+			c.remember(ids)
+		}
+	}
+	c.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Printf("Failed to invalidate tasks whose cache writes were skipped: %v", err)
+	case all:
+		log.Printf("Cleared the cache, since too many cache writes were skipped")
+	default:
+		log.Printf("Invalidated %d tasks whose cache writes were skipped", count)
+	}
+}
+
+// down reports whether a call failed because the cache is unavailable or its
+// breaker open.
+func down(err error) bool {
+	return err != nil && (errors.Is(err, resilience.ErrOpen) || unavailable(err))
+}
+
+// unavailable reports whether err suggests the cache is down, as opposed to a
+// miss or an error of the operation. A deadline exceeded is the operation
+// timeout: the breaker ignores failures once the caller's context is done.
+func unavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) && !errors.Is(err, redis.Nil) {
+		// Replies of servers that are loading, failing over or cut off from
+		// their cluster.
+		for _, prefix := range []string{"LOADING", "MASTERDOWN", "CLUSTERDOWN", "READONLY", "TRYAGAIN"} {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestResilientCacheInvalidatesSkippedWrites checks that writes skipped while
+// Redis is down leave nothing stale to read once it is back.
+func TestResilientCacheInvalidatesSkippedWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// skipped is the number of tasks whose writes are skipped, besides
+		// the update of a.
+		skipped int
+	}{
+		{"invalidates the tasks", 0},
+		{"clears the cache on overflow", maxPendingInvalidations},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := miniredis.RunT(t)
+			cfg := testConfig(10)
+			cfg.Addr = m.Addr()
+			cfg.Timeout = time.Second
+			redisCache := connect(t, NewRedisCache(cfg))
+			c := NewResilientCache(redisCache, "test-redis", 1, time.Millisecond, true)
+
+			add(t, ctx, c, testTask("a", 1), testTask("b", 1))
+			complete(t, ctx, c)
+
+			m.Close()
+			if err := c.UpdateTask(ctx, testTask("a", 2)); err != nil {
+				t.Fatalf("UpdateTask while Redis is down = %v, want it skipped", err)
+			}
+			if tc.skipped > 0 {
+				ids := make([]string, tc.skipped)
+				for i := range ids {
+					ids[i] = fmt.Sprintf("skipped-%d", i)
+				}
+				if err := c.DeleteTasks(ctx, ids); err != nil {
+					t.Fatalf("DeleteTasks while Redis is down = %v, want it skipped", err)
+				}
+			}
+			if err := m.Restart(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Redis still holds the first version of a, which must not be
+			// read.
+			if task, err := c.GetTask(ctx, "a"); !errors.Is(err, ErrNotCached) {
+				t.Fatalf("GetTask of a stale task = %+v, %v, want ErrNotCached", task, err)
+			}
+			wantComplete(t, ctx, c, false)
+
+			// Requests keep probing the cache until it answers.
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				c.IsComplete(ctx)
+				c.mu.Lock()
+				done := !c.stale && !c.flushing
+				c.mu.Unlock()
+				if done {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("skipped writes weren't invalidated")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			wantMiss(t, ctx, redisCache, "a")
+			if tc.skipped > 0 {
+				wantMiss(t, ctx, redisCache, "b")
+			} else {
+				wantTask(t, ctx, c, testTask("b", 1))
+			}
+			wantComplete(t, ctx, redisCache, false)
+
+			// Reads are served again.
+			add(t, ctx, c, testTask("a", 3))
+			wantTask(t, ctx, c, testTask("a", 3))
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package cache
+
+import "github.com/go-redis/redis/v8"
+
+// missingValue is stored under the key of a task known not to exist.
+const missingValue = "-"
+
+// completeValue marks the cache as holding every task.
+const completeValue = "complete"
+
+// The scripts below run on one shard of the keyspace and share its key
+// layout: KEYS[1] is the creation time index, KEYS[2] the meta hash, KEYS[3]
+// the access index, KEYS[4] and KEYS[5] the updated_at and priority indexes,
+// KEYS[6] the completeness marker, and KEYS[7] onwards the keys of the tasks
+// in ARGV; see keyspace.indexKeys. Every key a script touches is passed in
+// KEYS. Running each operation as one script makes it atomic, so no index
+// ever points at a task key that was not written.
+
+// unindexTask removes a task from every index of the shard, leaving its key.
+const unindexTask = `
+local function unindex(id)
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZREM', KEYS[4], id)
+	redis.call('ZREM', KEYS[5], id)
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('ZREM', KEYS[3], id)
+end
+`
+
+// trimIndex unindexes the lowest scored tasks other than keep until at most
+// max remain in the access index, and returns their IDs. Their keys are not
+// among the KEYS of the write, so the caller deletes them with purgeScript.
+// Evicting anything means the cache no longer holds every task.
+const trimIndex = `
+local function trim(max, keep)
+	local excess = redis.call('ZCARD', KEYS[3]) - max
+	local evicted = {}
+	if excess <= 0 then
+		return evicted
+	end
+	for _, id in ipairs(redis.call('ZRANGE', KEYS[3], 0, excess)) do
+		if #evicted < excess and id ~= keep then
+			unindex(id)
+			evicted[#evicted + 1] = id
+		end
+	end
+	redis.call('DEL', KEYS[6])
+	return evicted
+end
+`
+
+// lfuTouch counts an access to id. With insert set, a missing task enters the
+// index with the score of the least used one, so new entries are not evicted
+// straight away by long-lived popular ones.
+const lfuTouch = `
+local function lfu_touch(id, insert)
+	if redis.call('ZSCORE', KEYS[3], id) then
+		return redis.call('ZINCRBY', KEYS[3], 1, id)
+	end
+	if not insert then
+		return 0
+	end
+	local lowest = redis.call('ZRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+	local score = 1
+	if lowest[2] then
+		score = tonumber(lowest[2])
+	end
+	return redis.call('ZADD', KEYS[3], score, id)
+end
+`
+
+// writeScript stores the task ARGV[1] under KEYS[7] and updates every index
+// in one step. It returns the IDs of the tasks it evicted.
+// ARGV: 2 JSON, 3 status, 4 priority, 5 created_at score, 6 updated_at
+// score, 7 TTL in milliseconds (0 for none), 8 eviction policy, 9 max entries
+// of the shard, 10 current time in milliseconds, 11 "1" to leave a cached
+// task untouched.
+var writeScript = redis.NewScript(unindexTask + trimIndex + lfuTouch + `
+local id, key = ARGV[1], KEYS[7]
+local ttl, policy = tonumber(ARGV[7]), ARGV[8]
+
+if ARGV[11] == '1' then
+	local current = redis.call('GET', key)
+	if current and current ~= '` + missingValue + `' then
+		return {}
+	end
+end
+
+if ttl > 0 then
+	redis.call('SET', key, ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', key, ARGV[2])
+end
+redis.call('ZADD', KEYS[1], ARGV[5], id)
+redis.call('ZADD', KEYS[4], ARGV[6], id)
+redis.call('ZADD', KEYS[5], ARGV[4], id)
+redis.call('HSET', KEYS[2], id, cjson.encode({status = ARGV[3], priority = ARGV[4]}))
+
+if policy == 'lru' then
+	redis.call('ZADD', KEYS[3], ARGV[10], id)
+elseif policy == 'lfu' then
+	lfu_touch(id, true)
+else
+	return {}
+end
+return trim(tonumber(ARGV[9]), id)
+`)
+
+// purgeScript deletes the keys of the tasks in ARGV evicted by writeScript,
+// unless they were written again since.
+var purgeScript = redis.NewScript(`
+local purged = 0
+for i, id in ipairs(ARGV) do
+	if not redis.call('ZSCORE', KEYS[3], id) then
+		purged = purged + redis.call('DEL', KEYS[6 + i])
+	end
+end
+return purged
+`)
+
+// deleteScript drops the task ARGV[1].
+var deleteScript = redis.NewScript(unindexTask + `
+unindex(ARGV[1])
+redis.call('DEL', KEYS[7])
+return 1
+`)
+
+// reapScript unindexes the tasks in ARGV whose keys no longer exist, i.e.
+// that expired or were evicted by Redis' maxmemory-policy.
+var reapScript = redis.NewScript(unindexTask + `
+local reaped = 0
+for i, id in ipairs(ARGV) do
+	if redis.call('EXISTS', KEYS[6 + i]) == 0 then
+		unindex(id)
+		reaped = reaped + 1
+	end
+end
+if reaped > 0 then
+	redis.call('DEL', KEYS[6])
+end
+return reaped
+`)
+
+// lfuTouchScript counts a read of ARGV[1] if it is still cached.
+var lfuTouchScript = redis.NewScript(lfuTouch + `
+return lfu_touch(ARGV[1], false)
+`)
+
+// listScript returns the IDs and sort scores of the first tasks of the shard
+// matching status and priority filters, ordered by one of the sort indexes
+// and then by ID like database listings. The caller merges the shards and
+// reads the values of the page. It returns nil when required and the shard
+// isn't marked complete.
+// ARGV: 1 index of the sort key in KEYS, 2 "1" for descending, 3 number of
+// tasks to return, 4 "1" to require completeness, 5 number of statuses
+// followed by the statuses, then the number of priorities followed by the
+// priorities.
+var listScript = redis.NewScript(`
+if ARGV[4] == '1' and redis.call('GET', KEYS[6]) ~= '` + completeValue + `' then
+	return false
+end
+
+local index, desc, limit = KEYS[tonumber(ARGV[1])], ARGV[2] == '1', tonumber(ARGV[3])
+local i = 5
+local function set()
+	local n = tonumber(ARGV[i])
+	i = i + 1
+	if n == 0 then
+		return nil
+	end
+	local s = {}
+	for j = 1, n do
+		s[ARGV[i]] = true
+		i = i + 1
+	end
+	return s
+end
+local statuses = set()
+local priorities = set()
+
+local ids, scores = {}, {}
+local function take(id, score)
+	if statuses or priorities then
+		local meta = cjson.decode(redis.call('HGET', KEYS[2], id) or '{}')
+		if (statuses and not statuses[meta.status]) or (priorities and not priorities[meta.priority]) then
+			return
+		end
+	end
+	ids[#ids + 1] = id
+	scores[#scores + 1] = score
+end
+
+-- Descending walks reverse each run of equal scores, so ties stay ordered
+-- by ID. A run cut off by the limit only holds tasks past it.
+local batch = math.max(limit, 100)
+local offset = 0
+local run, runScore = {}, nil
+local function flush()
+	for j = #run, 1, -1 do
+		take(run[j], runScore)
+	end
+	run = {}
+end
+while #ids < limit do
+	local page
+	if desc then
+		page = redis.call('ZREVRANGE', index, offset, offset + batch - 1, 'WITHSCORES')
+	else
+		page = redis.call('ZRANGE', index, offset, offset + batch - 1, 'WITHSCORES')
+	end
+	if #page == 0 then
+		break
+	end
+	for j = 1, #page, 2 do
+		if not desc then
+			take(page[j], page[j + 1])
+		else
+			if page[j + 1] ~= runScore then
+				flush()
+				runScore = page[j + 1]
+			end
+			run[#run + 1] = page[j]
+		end
+	end
+	offset = offset + batch
+end
+flush()
+for j = #ids, limit + 1, -1 do
+	ids[j], scores[j] = nil, nil
+end
+return {ids, scores}
+`)
+
+// completeRebuildScript marks the shard complete if its marker KEYS[1] still
+// holds the rebuild token ARGV[1], i.e. nothing was evicted from it since the
+// rebuild began. The marker keeps its TTL.
+var completeRebuildScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], '` + completeValue + `', 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], '` + completeValue + `')
+end
+return 1
+`)
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// TieredCache keeps recently read tasks in process (L1) in front of a shared
+// cache such as RedisCache (L2). Writes go to L2 and are announced on an
+// Invalidator, so other instances drop their L1 copies; since announcements
+// may be lost, L1 entries also expire after a short TTL. Listings are always
+// served by L2.
+type TieredCache struct {
+	l2          Cache
+	invalidator Invalidator
+
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	// gen counts invalidations, so a task read from L2 isn't stored in L1
+	// when it may have changed during the read.
+	gen uint64
+}
+
+type localEntry struct {
+	task    models.Task
+	expires time.Time
+}
+
+// NewTieredCache returns a cache holding up to maxEntries tasks for at most
+// ttl in process in front of the connected cache l2.
+func NewTieredCache(l2 Cache, invalidator Invalidator, maxEntries int, ttl time.Duration) *TieredCache {
+	return &TieredCache{
+		l2:          l2,
+		invalidator: invalidator,
+		max:         maxEntries,
+		ttl:         ttl,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Connect subscribes to invalidations. L2 must already be connected.
+func (t *TieredCache) Connect() (Cache, error) {
+	if t.max <= 0 || t.ttl <= 0 {
+		return nil, fmt.Errorf("local cache requires positive max entries and ttl")
+	}
+	if err := t.invalidator.Subscribe(t.evict); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TieredCache) Close() error {
+	if err := t.invalidator.Close(); err != nil {
+		log.Printf("Failed to close cache invalidation subscription: %v", err)
+	}
+	return t.l2.Close()
+}
+
+func (t *TieredCache) AddTask(ctx context.Context, task Task) error {
+	return t.write(ctx, task.ID, func() error { return t.l2.AddTask(ctx, task) })
+}
+
+func (t *TieredCache) GetTask(ctx context.Context, id string) (Task, error) {
+	t.mu.Lock()
+	if el, ok := t.entries[id]; ok {
+		entry := el.Value.(*localEntry)
+		if time.Now().Before(entry.expires) {
+			t.order.MoveToFront(el)
+			t.mu.Unlock()
+			return entry.task, nil
+		}
+		t.remove(el)
+	}
+	gen := t.gen
+	t.mu.Unlock()
+
+	task, err := t.l2.GetTask(ctx, id)
+	if err != nil {
+		return task, err
+	}
+	t.store(task, gen)
+	return task, nil
+}
+
+// FillTask only reaches L2; the task enters L1 when it is next read.
+func (t *TieredCache) FillTask(ctx context.Context, task Task) error {
+	return t.l2.FillTask(ctx, task)
+}
+
+func (t *TieredCache) MarkMissing(ctx context.Context, id string) error {
+	return t.l2.MarkMissing(ctx, id)
+}
+
+func (t *TieredCache) GetPaginatedTasks(ctx context.Context, page, pageSize int) ([]Task, error) {
+	return t.l2.GetPaginatedTasks(ctx, page, pageSize)
+}
+
+func (t *TieredCache) GetFilteredTasks(ctx context.Context, q ListQuery) ([]Task, error) {
+	return t.l2.GetFilteredTasks(ctx, q)
+}
+
+func (t *TieredCache) UpdateTask(ctx context.Context, task Task) error {
+	return t.write(ctx, task.ID, func() error { return t.l2.UpdateTask(ctx, task) })
+}
+
+func (t *TieredCache) DeleteTask(ctx context.Context, id string) error {
+	return t.write(ctx, id, func() error { return t.l2.DeleteTask(ctx, id) })
+}
+
+func (t *TieredCache) DeleteTasks(ctx context.Context, ids []string) error {
+	return t.writeAll(ctx, ids, func() error { return t.l2.DeleteTasks(ctx, ids) })
+}
+
+// Clear empties L1 and announces that every task may have changed.
+func (t *TieredCache) Clear(ctx context.Context) error {
+	return t.write(ctx, "", func() error { return t.l2.Clear(ctx) })
+}
+
+func (t *TieredCache) WriteBatch(ctx context.Context, tasks []Task, deleted []string) error {
+	ids := append([]string(nil), deleted...)
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return t.writeAll(ctx, ids, func() error { return t.l2.WriteBatch(ctx, tasks, deleted) })
+}
+
+func (t *TieredCache) BeginRebuild(ctx context.Context) (string, error) {
+	return t.l2.BeginRebuild(ctx)
+}
+
+func (t *TieredCache) CompleteRebuild(ctx context.Context, token string) (bool, error) {
+	return t.l2.CompleteRebuild(ctx, token)
+}
+
+func (t *TieredCache) IsComplete(ctx context.Context) (bool, error) {
+	return t.l2.IsComplete(ctx)
+}
+
+// write drops id from L1, or every task for "", applies the change to L2 and
+// announces it. The change is already durable when announcing fails, so that
+// is only logged; other instances catch up when their L1 entries expire.
+func (t *TieredCache) write(ctx context.Context, id string, apply func() error) error {
+	return t.writeAll(ctx, []string{id}, apply)
+}
+
+// writeAll is write for changes of several tasks. The tasks are evicted again
+// once L2 was written, so a read that loaded the old version from L2 in the
+// meantime doesn't keep it in L1.
+func (t *TieredCache) writeAll(ctx context.Context, ids []string, apply func() error) error {
+	for _, id := range ids {
+		t.evict(id)
+	}
+	err := apply()
+	for _, id := range ids {
+		t.evict(id)
+	}
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := t.invalidator.Publish(ctx, id); err != nil {
+			log.Printf("Failed to publish cache invalidation for task %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// evict drops id from L1, or every task for "".
+func (t *TieredCache) evict(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	if id == "" {
+		t.entries = make(map[string]*list.Element)
+		t.order.Init()
+		return
+	}
+	if el, ok := t.entries[id]; ok {
+		t.remove(el)
+	}
+}
+
+// store puts task into L1 unless anything was invalidated since gen.
+func (t *TieredCache) store(task Task, gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.gen != gen {
+		return
+	}
+	entry := &localEntry{task: task, expires: time.Now().Add(t.ttl)}
+	if el, ok := t.entries[task.ID]; ok {
+		el.Value = entry
+		t.order.MoveToFront(el)
+		return
+	}
+	t.entries[task.ID] = t.order.PushFront(entry)
+	for t.order.Len() > t.max {
+		t.remove(t.order.Back())
+	}
+}
+
+func (t *TieredCache) remove(el *list.Element) {
+	delete(t.entries, el.Value.(*localEntry).task.ID)
+	t.order.Remove(el)
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// beforeUpdateCache calls before ahead of every update of the wrapped cache.
+type beforeUpdateCache struct {
+	Cache
+	before func()
+}
+
+func (c *beforeUpdateCache) UpdateTask(ctx context.Context, task Task) error {
+	c.before()
+	return c.Cache.UpdateTask(ctx, task)
+}
+
+// TestTieredCacheWriteRacingRead checks that a read loading the old version
+// of a task from L2 while it is written doesn't keep that version in L1.
+func TestTieredCacheWriteRacingRead(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig(10)
+	cfg.Addr = miniredis.RunT(t).Addr()
+	redisCache := NewRedisCache(cfg)
+	if _, err := redisCache.Connect(); err != nil {
+		t.Fatalf("failed to connect cache: %v", err)
+	}
+	l2 := &beforeUpdateCache{Cache: redisCache}
+	c := connect(t, NewTieredCache(l2, NewRedisInvalidator(redisCache), cfg.MaxEntries, time.Minute))
+
+	add(t, ctx, c, testTask("a", 1))
+	l2.before = func() {
+		// The read runs after L1 was evicted but before L2 is written.
+		wantTask(t, ctx, c, testTask("a", 1))
+	}
+	if err := c.UpdateTask(ctx, testTask("a", 2)); err != nil {
+		t.Fatal(err)
+	}
+	wantTask(t, ctx, c, testTask("a", 2))
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/drive-deep/task-microservice/cache"
+	"github.com/drive-deep/task-microservice/config"
+	"github.com/drive-deep/task-microservice/database"
+	"github.com/drive-deep/task-microservice/services"
+)
+
+const usage = `usage:
+  main                                   run the server
+  main migrate up                        apply pending schema migrations
+  main migrate down [-steps N]           revert the last N applied migrations (default 1)
+  main migrate status                    list migrations and whether they are applied
+  main cache rebuild [-batch-size N] [-rate N]
+                                         write every task from the database to the cache
+  main cache cleanup -legacy [-dry-run]  migrate and delete keys of the unprefixed layout
+  main cache cleanup -version N [-dry-run]
+                                         delete keys of an old cache schema version
+  main citus nodes                       list the nodes registered with the coordinator
+  main citus add-node host:port          register a Citus worker
+  main citus remove-node host:port       drain a Citus worker and unregister it
+  main citus rebalance                   rebalance shards over the workers
+  main archive [-batch-size N] [-max-batches N]
+                                         archive old tasks in terminal statuses once`
+
+// runCommand runs the administrative subcommand given by args instead of the
+// server.
+func runCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "cache":
+		return runCacheCommand(ctx, cfg, args[1:])
+	case "citus":
+		return runCitusCommand(cfg, args[1:])
+	case "migrate":
+		return runMigrateCommand(cfg, args[1:])
+	case "archive":
+		return runArchive(ctx, cfg, args[1:])
+	}
+	return errors.New(usage)
+}
+
+func runCitusCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if cfg.Database.Driver == database.DriverSQLite || !cfg.Database.Citus.Enabled {
+		return errors.New("citus is disabled in the configuration")
+	}
+	postgres := database.NewPostgresDB(cfg)
+	if _, err := postgres.Open(); err != nil {
+		return fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+	defer postgres.Close()
+
+	switch {
+	case args[0] == "nodes" && len(args) == 1:
+		nodes, err := postgres.CitusNodes()
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			state := "active"
+			if !n.Active {
+				state = "inactive"
+			}
+			fmt.Printf("%-30s %-10s %-8s %d shards\n", n, n.Role, state, n.Shards)
+		}
+		return nil
+	case args[0] == "add-node" && len(args) == 2:
+		added, err := postgres.AddCitusNode(args[1])
+		if err != nil {
+			return err
+		}
+		if added {
+			log.Printf("Added worker node %s; run citus rebalance to move shards onto it", args[1])
+		} else {
+			log.Printf("Worker node %s is already registered", args[1])
+		}
+		return nil
+	case args[0] == "remove-node" && len(args) == 2:
+		if err := postgres.RemoveCitusNode(args[1]); err != nil {
+			return err
+		}
+		log.Printf("Removed worker node %s", args[1])
+		return nil
+	case args[0] == "rebalance" && len(args) == 1:
+		err := postgres.RebalanceShards(func(p database.RebalanceProgress) {
+			log.Printf("Rebalancing: moved %d of %d shards", p.Moved, p.Total)
+		})
+		if err != nil {
+			return err
+		}
+		log.Println("Shards are balanced")
+		return nil
+	}
+	return errors.New(usage)
+}
+
+func runMigrateCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if cfg.Database.Driver == database.DriverSQLite {
+		return errors.New("migrations only apply to PostgreSQL; the sqlite driver creates its schema on connect")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	postgres := database.NewPostgresDB(cfg)
+	if _, err := postgres.Open(); err != nil {
+		return fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+	defer postgres.Close()
+	migrator, err := postgres.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(*steps)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return errors.New(usage)
+}
+
+func runCacheCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "cleanup":
+		return runCacheCleanup(ctx, cfg, args[1:])
+	case "rebuild":
+		return runCacheRebuild(ctx, cfg, args[1:])
+	}
+	return errors.New(usage)
+}
+
+func runCacheRebuild(ctx context.Context, cfg *config.Config, args []string) error {
+	opts := rebuildOptions(cfg)
+	flags := flag.NewFlagSet("cache rebuild", flag.ContinueOnError)
+	flags.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "tasks read per query")
+	flags.IntVar(&opts.Rate, "rate", opts.Rate, "maximum tasks read per second, 0 for no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	taskCache, err := connectCache(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cache: %w", err)
+	}
+	defer taskCache.Close()
+
+	service := services.NewTaskService(db.Tasks(), taskCache, cfg)
+	result, err := service.RebuildCache(ctx, opts)
+	if err != nil {
+		return err
+	}
+	logRebuild(result)
+	return nil
+}
+
+// warmUpCache rebuilds the cache unless it already holds every task, e.g.
+// because another instance warmed it up.
+func warmUpCache(ctx context.Context, cfg *config.Config, service *services.TaskService) {
+	if cfg.Cache.Backend == cache.BackendNone {
+		return
+	}
+	complete, err := service.CacheIsComplete(ctx)
+	if err != nil {
+		log.Printf("Skipping cache warm-up: %v", err)
+		return
+	}
+	if complete {
+		return
+	}
+	log.Println("Warming up cache")
+	result, err := service.RebuildCache(ctx, rebuildOptions(cfg))
+	if err != nil {
+		log.Printf("Cache warm-up failed: %v", err)
+		return
+	}
+	logRebuild(result)
+}
+
+func rebuildOptions(cfg *config.Config) services.RebuildOptions {
+	batchSize := cfg.Cache.RebuildBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRebuildBatchSize
+	}
+	return services.RebuildOptions{
+		BatchSize: batchSize,
+		Rate:      cfg.Cache.RebuildRate,
+		Progress:  logProgress(),
+	}
+}
+
+const defaultRebuildBatchSize = 500
+
+// logProgress returns a progress callback logging at most every few seconds.
+func logProgress() func(done int, total int64) {
+	var last time.Time
+	return func(done int, total int64) {
+		if time.Since(last) < 5*time.Second {
+			return
+		}
+		last = time.Now()
+		if total > 0 {
+			log.Printf("Cached %d of about %d tasks (%d%%)", done, total, min(100, int64(done)*100/total))
+		} else {
+			log.Printf("Cached %d tasks", done)
+		}
+	}
+}
+
+func logRebuild(result services.RebuildResult) {
+	if result.Complete {
+		log.Printf("Cached all %d tasks", result.Tasks)
+	} else {
+		log.Printf("Cached %d tasks; the cache can't serve filtered listings since tasks were evicted meanwhile", result.Tasks)
+	}
+}
+
+func runCacheCleanup(ctx context.Context, cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("cache cleanup", flag.ContinueOnError)
+	legacy := flags.Bool("legacy", false, "migrate and delete keys of the unprefixed layout")
+	version := flags.Int("version", 0, "delete keys of this cache schema version")
+	dryRun := flags.Bool("dry-run", false, "only report what would be done")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *legacy == (*version != 0) {
+		return errors.New(usage)
+	}
+
+	redisCache := cache.NewRedisCache(cfg.Redis)
+	if _, err := redisCache.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	defer redisCache.Close()
+
+	var report cache.CleanupReport
+	var err error
+	if *legacy {
+		report, err = redisCache.MigrateLegacyKeys(ctx, *dryRun)
+	} else {
+		report, err = redisCache.DropSchemaVersion(ctx, *version, *dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	log.Printf("%s %d keys, migrated %d tasks", verb, report.Deleted, report.Migrated)
+	return nil
+}
+
+func runArchive(ctx context.Context, cfg *config.Config, args []string) error {
+	opts := archiveOptions(cfg)
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	flags.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "tasks moved per transaction")
+	flags.IntVar(&opts.MaxBatches, "max-batches", opts.MaxBatches, "maximum batches, 0 for no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	taskCache, err := connectCache(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cache: %w", err)
+	}
+	defer taskCache.Close()
+
+	service := services.NewTaskService(db.Tasks(), taskCache, cfg)
+	archived, err := service.ArchiveTasks(ctx, opts)
+	log.Printf("Archived %d tasks", archived)
+	return err
+}
+
+// archiveTasks archives old tasks every configured interval until ctx is
+// done. Instances may archive at the same time; moving a task twice is
+// harmless.
+func archiveTasks(ctx context.Context, cfg *config.Config, service *services.TaskService) {
+	if cfg.Archive.Interval <= 0 {
+		log.Println("Archiving disabled: no interval configured")
+		return
+	}
+	opts := archiveOptions(cfg)
+	ticker := time.NewTicker(cfg.Archive.Interval)
+	defer ticker.Stop()
+	for {
+		archived, err := service.ArchiveTasks(ctx, opts)
+		if err != nil {
+			log.Printf("Archiving failed after %d tasks: %v", archived, err)
+		} else if archived > 0 {
+			log.Printf("Archived %d tasks", archived)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func archiveOptions(cfg *config.Config) services.ArchiveOptions {
+	return services.ArchiveOptions{
+		Statuses:   cfg.Archive.Statuses,
+		Age:        cfg.Archive.Age,
+		BatchSize:  cfg.Archive.BatchSize,
+		MaxBatches: cfg.Archive.MaxBatches,
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 
 	"github.com/drive-deep/task-microservice/cache"
 	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/database"
 	"github.com/drive-deep/task-microservice/message_queue"
-	"github.com/drive-deep/task-microservice/repositories"
 	"github.com/drive-deep/task-microservice/routes"
 	"github.com/drive-deep/task-microservice/services"
 	"github.com/gorilla/mux"
@@ -21,33 +24,111 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	postgres, err := database.NewPostgresDB().Connect()
+	if len(os.Args) > 1 {
+		// Interrupting a command cancels its work, e.g. a cache rebuild.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := runCommand(ctx, cfg, os.Args[1:])
+		stop()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	ctx := context.Background()
+
+	db, err := database.New(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to Postgres: %v", err)
+		log.Fatal(err)
+	}
+	if _, err := db.Connect(); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	defer db.Close()
 
-	redis, err := cache.NewRedisCache(20).Connect()
+	redis, err := connectCache(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to connect to cache: %v", err)
 	}
 	defer redis.Close()
 
-	repo := repositories.NewTaskRepository(postgres)
+	// Kafka and HTTP share the service, so concurrent loads of a task are
+	// coalesced and jobs are tracked in one place whichever path serves them.
+	services := services.NewTaskService(db.Tasks(), redis, cfg)
 
 	// Initialize the Kafka message queue
-	kafkaMessageQueue, err := message_queue.NewKafkaMessageQueue(services.NewTaskService(repo, redis)).Connect([]string{cfg.Kafka.Broker}, cfg.Kafka.GroupID)
+	kafkaMessageQueue, err := message_queue.NewKafkaMessageQueue(services, cfg.Kafka.Timeout).Connect([]string{cfg.Kafka.Broker}, cfg.Kafka.GroupID)
 	if err != nil {
 		log.Fatalf("failed to connect to Kafka: %v", err)
 	}
-	go kafkaMessageQueue.StartConsuming(cfg.Kafka.Topics)
+	go kafkaMessageQueue.StartConsuming(ctx, cfg.Kafka.Topics)
 	defer kafkaMessageQueue.Close()
 
-	services := services.NewTaskService(repo, redis)
+	if cfg.Cache.WarmupOnStart {
+		go warmUpCache(ctx, cfg, services)
+	}
+	if cfg.Archive.Enabled {
+		go archiveTasks(ctx, cfg, services)
+	}
+
+	if cfg.Server.AdminAddr != "" {
+		go serveAdmin(cfg.Server.AdminAddr)
+	}
 
 	mux := mux.NewRouter()
-	routes.RegisterRoutes(mux, *services)
+	routes.RegisterRoutes(mux, *services, cfg)
 
 	log.Println("Server started on port 8080")
 	http.ListenAndServe(":8080", mux)
 
 }
+
+// serveAdmin serves the admin routes, such as metrics, on addr.
+func serveAdmin(addr string) {
+	router := mux.NewRouter()
+	routes.RegisterAdminRoutes(router)
+	log.Printf("Admin server started on %s", addr)
+	if err := http.ListenAndServe(addr, router); err != nil {
+		log.Printf("Admin server stopped: %v", err)
+	}
+}
+
+// connectCache connects the cache backend selected in the configuration.
+// Whatever the backend, it is wrapped in a ResilientCache reported as the
+// dependency of its name, which the service relies on to keep cache failures
+// from failing requests in degraded mode. The local tier goes below it, so
+// the invalidations of skipped writes reach other instances' local tiers.
+func connectCache(cfg *config.Config) (cache.Cache, error) {
+	var connected cache.Cache
+	var err error
+	name := cfg.Cache.Backend
+	switch name {
+	case cache.BackendRedis, "":
+		name = cache.BackendRedis
+		connected, err = connectRedis(cfg)
+	case cache.BackendMemory:
+		connected, err = cache.NewMemoryCache(cfg.Redis).Connect()
+	case cache.BackendNone:
+		connected, err = cache.NewNoopCache().Connect()
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Cache.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	breaker := cfg.Redis.Breaker
+	return cache.NewResilientCache(connected, name, breaker.FailureThreshold, breaker.OpenTimeout, cfg.Redis.DegradedMode), nil
+}
+
+// connectRedis connects Redis, behind the local tier if enabled.
+func connectRedis(cfg *config.Config) (cache.Cache, error) {
+	redisCache := cache.NewRedisCache(cfg.Redis)
+	redis, err := redisCache.Connect()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cache.LocalMaxEntries > 0 {
+		tiered := cache.NewTieredCache(redis, cache.NewRedisInvalidator(redisCache), cfg.Cache.LocalMaxEntries, cfg.Cache.LocalTTL)
+		return tiered.Connect()
+	}
+	return redis, nil
+}
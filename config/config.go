@@ -1,8 +1,8 @@
 package config
 
 import (
-    "log"
     "os"
+    "time"
 
     "gopkg.in/yaml.v2"
 )
@@ -11,33 +11,295 @@ type Config struct {
     Server   ServerConfig   `yaml:"server"`
     Database DatabaseConfig `yaml:"database"`
     Redis    RedisConfig    `yaml:"redis"`
+    Cache    CacheConfig    `yaml:"cache"`
     Kafka    KafkaConfig    `yaml:"kafka"`
+    Search   SearchConfig   `yaml:"search"`
+    BulkUpdate BulkUpdateConfig `yaml:"bulk_update"`
+    Jobs     JobsConfig     `yaml:"jobs"`
+    Import   ImportConfig   `yaml:"import"`
+    Export   ExportConfig   `yaml:"export"`
+    Archive  ArchiveConfig  `yaml:"archive"`
 }
 
 type ServerConfig struct {
     Port int `yaml:"port"`
     PageSize int `yaml:"page_size"`
     Page int `yaml:"page"`
+    MaxPageSize int `yaml:"max_page_size"`
+    // CountMode is the default way totals are computed for paginated
+    // listings: "exact" or "estimated".
+    CountMode string `yaml:"count_mode"`
+    // MaxBatchSize limits the operations of a batch request or message.
+    MaxBatchSize int `yaml:"max_batch_size"`
+    // AdminAddr is the address of the listener serving metrics at
+    // /debug/vars, apart from the public API. Empty disables it.
+    AdminAddr string `yaml:"admin_addr"`
 }
 
 type DatabaseConfig struct {
+    // Driver selects the database: "postgres" (the default) or "sqlite".
+    Driver string `yaml:"driver"`
+    // Path is the database file of the sqlite driver.
+    Path     string `yaml:"path"`
     Host     string `yaml:"host"`
     Port     int    `yaml:"port"`
     User     string `yaml:"user"`
     Password string `yaml:"password"`
     Name     string `yaml:"name"`
+    // Timeout bounds every query, in addition to the deadline of the
+    // request or message it serves; zero for none.
+    Timeout time.Duration `yaml:"timeout"`
+    // MigrateOnStart applies pending schema migrations when the server
+    // starts. Instances take turns using an advisory lock.
+    MigrateOnStart bool `yaml:"migrate_on_start"`
+    // RequireCurrentSchema refuses to start the server while migrations are
+    // pending, e.g. when migrations are run separately with "migrate up".
+    RequireCurrentSchema bool `yaml:"require_current_schema"`
+    Citus CitusConfig `yaml:"citus"`
+    Pool  PoolConfig  `yaml:"pool"`
+    SSL   SSLConfig   `yaml:"ssl"`
+    // Replicas are "host:port" addresses of read replicas, reached with the
+    // same credentials. Reads go to them unless the same request wrote
+    // before, or the client wrote within ReadYourWritesWindow.
+    Replicas []string `yaml:"replicas"`
+    // ReadYourWritesWindow is how long after a write the client's later
+    // HTTP requests read from the primary, tracked with a cookie. It should
+    // exceed the replication lag; zero only covers the writing request.
+    ReadYourWritesWindow time.Duration `yaml:"read_your_writes_window"`
+    // Retry runs queries again after transient failures such as
+    // serialization failures and lost connections.
+    Retry   RetryConfig   `yaml:"retry"`
+    Breaker BreakerConfig `yaml:"breaker"`
+}
+
+// RetryConfig retries failed calls with exponential backoff. Each wait is
+// random, up to BaseDelay doubled after every attempt and capped at MaxDelay.
+type RetryConfig struct {
+    // MaxAttempts includes the first call; zero or one disables retries.
+    MaxAttempts int           `yaml:"max_attempts"`
+    BaseDelay   time.Duration `yaml:"base_delay"`
+    MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+// BreakerConfig configures the circuit breaker of a dependency. After
+// FailureThreshold consecutive failures calls fail immediately for
+// OpenTimeout, then a single call is let through to probe the dependency.
+// A zero threshold disables the breaker.
+type BreakerConfig struct {
+    FailureThreshold int           `yaml:"failure_threshold"`
+    OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+// PoolConfig tunes the connection pool kept for the primary and for each
+// replica. Zero values keep the database/sql defaults.
+type PoolConfig struct {
+    MaxOpenConns    int           `yaml:"max_open_conns"`
+    MaxIdleConns    int           `yaml:"max_idle_conns"`
+    ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+    ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// SSLConfig secures connections to PostgreSQL. Mode is a libpq sslmode such
+// as "disable", "require" or "verify-full"; empty means "disable". The
+// certificates are file paths.
+type SSLConfig struct {
+    Mode     string `yaml:"mode"`
+    RootCert string `yaml:"root_cert"`
+    Cert     string `yaml:"cert"`
+    Key      string `yaml:"key"`
+}
+
+// CitusConfig describes the Citus cluster behind the coordinator in
+// DatabaseConfig. With Enabled unset the database is plain PostgreSQL and
+// tables are not distributed.
+type CitusConfig struct {
+    Enabled bool `yaml:"enabled"`
+    // Workers are "host:port" addresses registered with the coordinator on
+    // start unless already present.
+    Workers []string `yaml:"workers"`
+    // ShardCount and ReplicationFactor apply to tables distributed by
+    // migrations; zero keeps the Citus defaults.
+    ShardCount int `yaml:"shard_count"`
+    ReplicationFactor int `yaml:"replication_factor"`
+    // DistributionColumn shards the tasks table. It must be "id", the
+    // primary key, and defaults to it; other values are rejected on start.
+    DistributionColumn string `yaml:"distribution_column"`
+}
+
+// CacheConfig selects the task cache.
+type CacheConfig struct {
+    // Backend is "redis" (the default), "memory" for an in-process cache
+    // for single-instance deployments, or "none". The memory backend uses
+    // the eviction settings of the redis section.
+    Backend string `yaml:"backend"`
+    // LocalMaxEntries is the number of tasks each instance keeps in process
+    // in front of Redis; zero disables the local tier.
+    LocalMaxEntries int `yaml:"local_max_entries"`
+    // LocalTTL bounds how long a local entry is served, in case an
+    // invalidation from another instance was lost.
+    LocalTTL time.Duration `yaml:"local_ttl"`
+    // WarmupOnStart rebuilds the cache from the database in the background
+    // when the server starts and the cache doesn't hold every task.
+    WarmupOnStart bool `yaml:"warmup_on_start"`
+    // RebuildBatchSize is the number of tasks read per query by warm-ups
+    // and the cache rebuild command.
+    RebuildBatchSize int `yaml:"rebuild_batch_size"`
+    // RebuildRate limits the tasks read per second by warm-ups and the
+    // cache rebuild command, so they don't overload the database; zero
+    // means no limit.
+    RebuildRate int `yaml:"rebuild_rate"`
 }
 
 type RedisConfig struct {
+    // Mode is "single" (the default), "sentinel" or "cluster".
+    Mode     string `yaml:"mode"`
+    // Addr is the server address in single mode.
     Addr     string `yaml:"addr"`
+    // Addrs are the sentinel addresses in sentinel mode and the seed nodes
+    // in cluster mode.
+    Addrs    []string `yaml:"addrs"`
+    // MasterName is the name of the master monitored by the sentinels.
+    MasterName string `yaml:"master_name"`
+    // SentinelUsername and SentinelPassword authenticate with the sentinels
+    // when they require it.
+    SentinelUsername string `yaml:"sentinel_username"`
+    SentinelPassword string `yaml:"sentinel_password"`
+    // Username selects a Redis 6 ACL user; empty uses the default user.
+    Username string `yaml:"username"`
     Password string `yaml:"password"`
+    // DB is ignored in cluster mode, which only has database 0.
     DB       int    `yaml:"db"`
+    TLS      TLSConfig `yaml:"tls"`
+    // KeyPrefix namespaces every cache key, so several services or
+    // environments can share one Redis. Defaults to "task-service".
+    KeyPrefix string `yaml:"key_prefix"`
+    // SchemaVersion is part of every cache key; bump it when the cached
+    // format changes so old entries are ignored rather than misread.
+    SchemaVersion int `yaml:"schema_version"`
+    // Shards spreads the cached tasks and their indexes over this many
+    // cluster hash tags. Defaults to 16 in cluster mode and 1 otherwise; it
+    // can only change together with SchemaVersion. The lru and lfu limits
+    // apply per shard, to MaxEntries divided by Shards.
+    Shards int `yaml:"shards"`
+    // MaxEntries bounds the number of cached tasks under the "lru" and "lfu"
+    // eviction policies.
+    MaxEntries int `yaml:"max_entries"`
+    // EvictionPolicy is "ttl" to rely on key expiry and Redis' own
+    // maxmemory-policy, or "lru"/"lfu" to keep a shared access index in
+    // Redis that every replica evicts from consistently.
+    EvictionPolicy string `yaml:"eviction_policy"`
+    // TTL expires cached tasks; required by the "ttl" policy and optional
+    // for the others.
+    TTL time.Duration `yaml:"ttl"`
+    // TTLJitter lengthens each TTL by a random fraction of up to this value,
+    // e.g. 0.1 for up to 10%, so entries cached together don't expire
+    // together.
+    TTLJitter float64 `yaml:"ttl_jitter"`
+    // NegativeTTL caches lookups of task IDs that don't exist for this long;
+    // zero disables negative caching.
+    NegativeTTL time.Duration `yaml:"negative_ttl"`
+    // MaxMemoryPolicy, when set, is applied with CONFIG SET on connect.
+    MaxMemoryPolicy string `yaml:"maxmemory_policy"`
+    // Timeout bounds every cache operation; zero for none. A timed out read
+    // falls back to the database.
+    Timeout time.Duration `yaml:"timeout"`
+    Breaker BreakerConfig `yaml:"breaker"`
+    // DegradedMode logs and skips failed cache writes instead of failing
+    // requests whose database write succeeded. The tasks concerned are
+    // invalidated once the cache is back, and reads bypass it until then.
+    // It applies to every cache backend, like Breaker.
+    DegradedMode bool `yaml:"degraded_mode"`
+}
+
+// TLSConfig configures TLS for a client connection.
+type TLSConfig struct {
+    Enabled bool `yaml:"enabled"`
+    // CAFile verifies the server with these CAs instead of the system ones.
+    CAFile string `yaml:"ca_file"`
+    // CertFile and KeyFile hold a client certificate for mutual TLS.
+    CertFile string `yaml:"cert_file"`
+    KeyFile  string `yaml:"key_file"`
+    // ServerName overrides the name the server certificate is checked
+    // against.
+    ServerName string `yaml:"server_name"`
+    InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
 }
 
 type KafkaConfig struct {
     Broker string `yaml:"broker"`
     GroupID string `yaml:"group_id"`
     Topics []string `yaml:"topics"`
+    // Timeout bounds sending a message and processing a consumed one; zero
+    // for none.
+    Timeout time.Duration `yaml:"timeout"`
+}
+
+// BulkUpdateConfig limits updates of every task matching a filter.
+type BulkUpdateConfig struct {
+    // MaxRows refuses updates matching more tasks; zero for no limit.
+    MaxRows int `yaml:"max_rows"`
+    // AsyncThreshold runs updates matching more tasks as background jobs;
+    // zero runs every update within the request.
+    AsyncThreshold int `yaml:"async_threshold"`
+    // ChunkSize is the number of tasks updated per transaction.
+    ChunkSize int `yaml:"chunk_size"`
+    // SampleSize is the number of matching task IDs a dry run returns.
+    SampleSize int `yaml:"sample_size"`
+}
+
+// ImportConfig limits task imports.
+type ImportConfig struct {
+    // MaxBytes limits the size of an uploaded file.
+    MaxBytes int64 `yaml:"max_bytes"`
+    // MaxRows limits the tasks of a file; zero for no limit.
+    MaxRows int `yaml:"max_rows"`
+    // AsyncThreshold imports files with more tasks as background jobs;
+    // zero imports every file within the request.
+    AsyncThreshold int `yaml:"async_threshold"`
+    // ChunkSize is the number of tasks written per transaction.
+    ChunkSize int `yaml:"chunk_size"`
+}
+
+// ExportConfig controls task exports.
+type ExportConfig struct {
+    // BatchSize is the number of tasks read per query; an export holds no
+    // more in memory.
+    BatchSize int `yaml:"batch_size"`
+}
+
+// ArchiveConfig moves old tasks in terminal statuses out of the tasks table.
+type ArchiveConfig struct {
+    // Enabled runs archiving in the server every Interval.
+    Enabled  bool          `yaml:"enabled"`
+    Interval time.Duration `yaml:"interval"`
+    // Statuses are the terminal statuses of tasks that may be archived.
+    Statuses []string `yaml:"statuses"`
+    // Age is how long ago tasks must have been last updated.
+    Age time.Duration `yaml:"age"`
+    // BatchSize is the number of tasks moved per transaction, and
+    // MaxBatches bounds the batches of a run; zero means no limit.
+    BatchSize  int `yaml:"batch_size"`
+    MaxBatches int `yaml:"max_batches"`
+}
+
+// JobsConfig controls background jobs.
+type JobsConfig struct {
+    // Retention is how long finished jobs can be looked up; zero means one
+    // hour.
+    Retention time.Duration `yaml:"retention"`
+    // StaleAfter is how long a running job may go without a heartbeat from
+    // its instance before it is reported failed, e.g. after the instance
+    // was restarted; zero means one minute.
+    StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+type SearchConfig struct {
+    // Language is the text search configuration the indexed search_vector
+    // column is built with.
+    Language string `yaml:"language"`
+    // Languages lists the configurations clients may select per request;
+    // any other than Language is evaluated without the index.
+    Languages []string `yaml:"languages"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -54,12 +316,4 @@ func LoadConfig() (*Config, error) {
     }
 
     return &config, nil
-}
-
-func GetConfig() *Config {
-    config, err := LoadConfig()
-    if err != nil {
-        log.Fatalf("could not load config: %v", err)
-    }
-    return config
 }
\ No newline at end of file
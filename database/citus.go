@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// defaultPort is used for worker and replica addresses given without a port.
+const defaultPort = 5432
+
+// rebalancePollInterval is how often RebalanceShards reports progress.
+const rebalancePollInterval = 2 * time.Second
+
+// CitusNode is a node registered with the Citus coordinator.
+type CitusNode struct {
+	Host   string
+	Port   int
+	Role   string
+	Active bool
+	// Shards is the number of shard placements on the node.
+	Shards int
+}
+
+func (n CitusNode) String() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// RebalanceProgress counts the shard moves of a running rebalance.
+type RebalanceProgress struct {
+	Moved, Total int
+}
+
+// CitusNodes lists the nodes registered with the coordinator.
+func (p *PostgresDB) CitusNodes() ([]CitusNode, error) {
+	var nodes []CitusNode
+	err := p.db.Raw(`SELECT n.nodename AS host, n.nodeport AS port, n.noderole AS role, n.isactive AS active,
+		(SELECT count(*) FROM pg_dist_placement pl WHERE pl.groupid = n.groupid) AS shards
+		FROM pg_dist_node n ORDER BY n.nodename, n.nodeport`).Scan(&nodes).Error
+	return nodes, err
+}
+
+// AddCitusNode registers the worker at addr ("host" or "host:port") unless it
+// already is, and reports whether it was added.
+func (p *PostgresDB) AddCitusNode(addr string) (bool, error) {
+	host, port, err := splitNodeAddr(addr)
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	err = p.db.Raw("SELECT count(*) FROM pg_dist_node WHERE nodename = ? AND nodeport = ?", host, port).Scan(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := p.db.Exec("SELECT citus_add_node(?, ?)", host, port).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveCitusNode moves the shards off the worker at addr and unregisters it.
+func (p *PostgresDB) RemoveCitusNode(addr string) error {
+	host, port, err := splitNodeAddr(addr)
+	if err != nil {
+		return err
+	}
+	if err := p.db.Exec("SELECT citus_drain_node(?, ?)", host, port).Error; err != nil {
+		return fmt.Errorf("failed to drain node: %w", err)
+	}
+	return p.db.Exec("SELECT citus_remove_node(?, ?)", host, port).Error
+}
+
+// RebalanceShards runs rebalance_table_shards, which blocks until the shards
+// are spread evenly over the workers, and calls progress every few seconds
+// while it runs.
+func (p *PostgresDB) RebalanceShards(progress func(RebalanceProgress)) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.db.Exec("SELECT rebalance_table_shards()").Error
+	}()
+
+	ticker := time.NewTicker(rebalancePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if progress == nil {
+				continue
+			}
+			// The rebalance holds its own connection, so the pool polls on
+			// another one.
+			ctx, cancel := context.WithTimeout(context.Background(), rebalancePollInterval)
+			var current RebalanceProgress
+			err := p.db.WithContext(ctx).Raw(`SELECT count(*) FILTER (WHERE progress = 2) AS moved, count(*) AS total
+				FROM get_rebalance_progress()`).Scan(&current).Error
+			cancel()
+			if err == nil && current.Total > 0 {
+				progress(current)
+			}
+		}
+	}
+}
+
+// splitNodeAddr splits a worker or replica address, defaulting the port.
+func splitNodeAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, defaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in node address %q", addr)
+	}
+	return host, port, nil
+}
@@ -1,16 +1,35 @@
 package database
 
 import (
+	"fmt"
+
+	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/repositories"
 	"gorm.io/gorm"
 )
 
+// Drivers selectable with config.DatabaseConfig.Driver.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// Database is a storage driver. Connect opens the database and prepares its
+// schema; Tasks then returns the repository adapter for it.
 type Database interface {
 	Connect() (*gorm.DB, error)
 	Close() error
-	CreateTask(task *models.Task) error
-	GetTaskByID(id string) (*models.Task, error)
-	GetAllTasksPaginated(page, pageSize int) ([]models.Task, error)
-	UpdateTask(task *models.Task) error
-	DeleteTask(id string) error
+	Tasks() repositories.Repository[models.Task]
+}
+
+// New returns the driver named in the configuration.
+func New(cfg *config.Config) (Database, error) {
+	switch cfg.Database.Driver {
+	case DriverPostgres, "":
+		return NewPostgresDB(cfg), nil
+	case DriverSQLite:
+		return NewSQLiteDB(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", cfg.Database.Driver)
 }
@@ -0,0 +1,330 @@
+package database
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the advisory lock held while migrating, so only
+// one instance migrates at a time.
+const migrationLockKey = 0x7461736b // "task"
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Directives recognised in migration files, each on a line of its own.
+// Section directives select the statements that follow them: "-- +citus"
+// only on Citus, "-- +no-citus" only on plain PostgreSQL, "-- +all" on both
+// (the default). "-- +no-transaction" runs the file outside a transaction,
+// for statements such as CREATE INDEX CONCURRENTLY or Citus node management.
+const (
+	directiveCitus         = "-- +citus"
+	directiveNoCitus       = "-- +no-citus"
+	directiveAll           = "-- +all"
+	directiveNoTransaction = "-- +no-transaction"
+)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration was applied and when.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// MigrationParams are substituted into migration files written as Go
+// templates, e.g. {{.SearchLanguage}}.
+type MigrationParams struct {
+	SearchLanguage string
+	// Distribution settings of tables sharded by Citus.
+	DistributionColumn string
+	ShardCount         int
+	ReplicationFactor  int
+}
+
+// Migrator applies and reverts the embedded migrations, recording applied
+// versions in the schema_migrations table.
+type Migrator struct {
+	db         *gorm.DB
+	citus      bool
+	params     MigrationParams
+	migrations []Migration
+}
+
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// NewMigrator loads the embedded migrations. With citus set, Citus sections
+// run instead of plain PostgreSQL ones.
+func NewMigrator(db *gorm.DB, citus bool, params MigrationParams) (*Migrator, error) {
+	if !identifierPattern.MatchString(params.SearchLanguage) {
+		return nil, fmt.Errorf("invalid search language %q", params.SearchLanguage)
+	}
+	if citus && !identifierPattern.MatchString(params.DistributionColumn) {
+		return nil, fmt.Errorf("invalid distribution column %q", params.DistributionColumn)
+	}
+	if params.ShardCount < 0 || params.ReplicationFactor < 0 {
+		return nil, fmt.Errorf("shard count and replication factor must not be negative")
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, citus: citus, params: params, migrations: migrations}, nil
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, name := range names {
+		match := migrationFilePattern.FindStringSubmatch(path.Base(name))
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>.(up|down).sql", name)
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status lists every migration with the time it was applied, if it was.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i].Migration = migration
+		if at, ok := applied[migration.Version]; ok {
+			at := at
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.run(conn, migration, migration.Up, func(tx *gorm.DB) error {
+				return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be reverted: it has no down file", migration.Version, migration.Name)
+			}
+			err := m.run(conn, migration, migration.Down, func(tx *gorm.DB) error {
+				return tx.Delete(&schemaMigration{}, "version = ?", migration.Version).Error
+			})
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// locked runs fn on a single connection holding the migration advisory lock,
+// waiting for other instances to finish migrating first.
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	return m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+
+		if err := conn.AutoMigrate(&schemaMigration{}); err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+		return fn(conn)
+	})
+}
+
+// run executes the sections of a migration file that apply to the database
+// and records the result with record, in one transaction unless the file
+// opts out.
+func (m *Migrator) run(conn *gorm.DB, migration Migration, source string, record func(tx *gorm.DB) error) error {
+	statements, transactional, err := m.render(source)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	apply := func(tx *gorm.DB) error {
+		for _, sql := range statements {
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+		return record(tx)
+	}
+	if transactional {
+		err = conn.Transaction(apply)
+	} else {
+		err = apply(conn)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// render expands the template of a migration file and returns the sections
+// that apply to the database and whether to use a transaction.
+func (m *Migrator) render(source string) ([]string, bool, error) {
+	tmpl, err := template.New("migration").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, false, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, m.params); err != nil {
+		return nil, false, err
+	}
+
+	var sections []string
+	var section strings.Builder
+	transactional, include := true, true
+	flush := func() {
+		if include && strings.TrimSpace(section.String()) != "" {
+			sections = append(sections, section.String())
+		}
+		section.Reset()
+	}
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		switch strings.TrimSpace(line) {
+		case directiveCitus:
+			flush()
+			include = m.citus
+		case directiveNoCitus:
+			flush()
+			include = !m.citus
+		case directiveAll:
+			flush()
+			include = true
+		case directiveNoTransaction:
+			transactional = false
+		default:
+			section.WriteString(line)
+		}
+	}
+	flush()
+	return sections, transactional, nil
+}
+
+func (m *Migrator) applied(db *gorm.DB) (map[int64]time.Time, error) {
+	applied := make(map[int64]time.Time)
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
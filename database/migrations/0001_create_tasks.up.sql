@@ -0,0 +1,12 @@
+-- The table as created by GORM's AutoMigrate, which managed the schema before
+-- versioned migrations; IF NOT EXISTS adopts existing databases.
+CREATE TABLE IF NOT EXISTS tasks (
+    id text PRIMARY KEY,
+    title varchar(100),
+    description text,
+    status varchar(20),
+    priority int,
+    created_at timestamp DEFAULT current_timestamp,
+    updated_at timestamp DEFAULT current_timestamp
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_id ON tasks (id);
@@ -0,0 +1,3 @@
+-- +citus
+SELECT undistribute_table('tasks')
+WHERE EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass);
@@ -0,0 +1,7 @@
+-- +citus
+-- Shard tasks by the configured distribution column. Tables added later that
+-- are joined with tasks should be distributed with colocate_with => 'tasks'.
+{{if .ShardCount}}SET LOCAL citus.shard_count = {{.ShardCount}};{{end}}
+{{if .ReplicationFactor}}SET LOCAL citus.shard_replication_factor = {{.ReplicationFactor}};{{end}}
+SELECT create_distributed_table('tasks', '{{.DistributionColumn}}')
+WHERE NOT EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass);
@@ -0,0 +1,8 @@
+-- Generated column and GIN index for full-text search; Citus propagates both
+-- to every shard.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('{{.SearchLanguage}}', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('{{.SearchLanguage}}', coalesce(description, '')), 'B')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS idx_tasks_search_vector ON tasks USING GIN (search_vector);
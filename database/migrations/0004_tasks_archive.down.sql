@@ -0,0 +1,6 @@
+-- Archived tasks are moved back rather than lost.
+INSERT INTO tasks (id, title, description, status, priority, created_at, updated_at)
+SELECT id, title, description, status, priority, created_at, updated_at FROM tasks_archive
+ON CONFLICT (id) DO NOTHING;
+DROP TABLE IF EXISTS tasks_archive;
+DROP INDEX IF EXISTS idx_tasks_status_updated_at;
@@ -0,0 +1,18 @@
+-- Archived tasks keep the columns of tasks, without search, and the time they
+-- were archived. The index serves the archiver's scan for old terminal tasks.
+CREATE TABLE IF NOT EXISTS tasks_archive (
+    id text PRIMARY KEY,
+    title varchar(100),
+    description text,
+    status varchar(20),
+    priority int,
+    created_at timestamp,
+    updated_at timestamp,
+    archived_at timestamp NOT NULL DEFAULT current_timestamp
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_status_updated_at ON tasks (status, updated_at);
+-- +citus
+-- Colocated with tasks, so moving a task between the tables stays on its
+-- shard's node.
+SELECT create_distributed_table('tasks_archive', '{{.DistributionColumn}}', colocate_with => 'tasks')
+WHERE NOT EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks_archive'::regclass);
@@ -0,0 +1,17 @@
+-- Background jobs, so any instance can report them and they outlive the one
+-- running them. updated_at is the heartbeat of running jobs; the index serves
+-- the search for stale ones and the pruning of finished ones. The table stays
+-- local to the coordinator on Citus.
+CREATE TABLE IF NOT EXISTS jobs (
+    id text PRIMARY KEY,
+    kind text NOT NULL,
+    state text NOT NULL,
+    total bigint NOT NULL DEFAULT 0,
+    done bigint NOT NULL DEFAULT 0,
+    result text,
+    error text,
+    started_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL,
+    finished_at timestamp
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_state_updated_at ON jobs (state, updated_at);
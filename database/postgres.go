@@ -3,97 +3,205 @@ package database
 import (
 	"fmt"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/repositories"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// identifierPattern restricts configured names spliced into SQL, such as the
+// search language and the distribution column.
+var identifierPattern = regexp.MustCompile(`^[a-z_]+$`)
+
 type PostgresDB struct {
-	db *gorm.DB
+	cfg      *config.Config
+	db       *gorm.DB
+	replicas []*gorm.DB
 }
 
-func NewPostgresDB() *PostgresDB {
-	return &PostgresDB{}
+func NewPostgresDB(cfg *config.Config) *PostgresDB {
+	return &PostgresDB{cfg: cfg}
 }
 
+// Connect opens the database and brings its schema up to date or checks it,
+// as configured.
 func (p *PostgresDB) Connect() (*gorm.DB, error) {
-	cfg := config.GetConfig()
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable",
-		cfg.Database.Host, cfg.Database.User, cfg.Database.Password, cfg.Database.Name, cfg.Database.Port)
-	var err error
-	p.db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	cfg := p.cfg
+	db, err := p.Open()
 	if err != nil {
 		return nil, err
 	}
 
-	// Enable Citus extension
-	if err := p.db.Exec("CREATE EXTENSION IF NOT EXISTS citus").Error; err != nil {
+	migrator, err := p.Migrator()
+	if err != nil {
 		return nil, err
 	}
+	if cfg.Database.MigrateOnStart {
+		applied, err := migrator.Up()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+	}
+	if cfg.Database.RequireCurrentSchema {
+		pending, err := migrator.Pending()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migrations: %w", err)
+		}
+		if len(pending) > 0 {
+			return nil, fmt.Errorf("database schema is behind: %d migrations pending, starting with %d_%s; run migrate up",
+				len(pending), pending[0].Version, pending[0].Name)
+		}
+	}
+	return db, nil
+}
 
-	// Add worker nodes
-	workerNodes := []string{"postgres-worker1:5432", "postgres-worker2:5432"}
-	for _, worker := range workerNodes {
-		if err := p.db.Exec("SELECT * from master_add_node(?)", worker).Error; err != nil {
-			log.Printf("Failed to add worker node %s: %v", worker, err)
+// Open connects to the database and its read replicas and, with Citus
+// enabled, registers the configured workers, without touching the schema.
+func (p *PostgresDB) Open() (*gorm.DB, error) {
+	cfg := p.cfg
+	var err error
+	p.db, err = openPostgres(cfg.Database, cfg.Database.Host, cfg.Database.Port)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range cfg.Database.Replicas {
+		host, port, err := splitNodeAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		replica, err := openPostgres(cfg.Database, host, port)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to replica %s: %w", addr, err)
 		}
+		p.replicas = append(p.replicas, replica)
+	}
+	if !cfg.Database.Citus.Enabled {
+		return p.db, nil
+	}
+	if _, err := distributionColumn(cfg.Database.Citus); err != nil {
+		return nil, err
 	}
-	// Run migrations on all models
-    if err := p.db.AutoMigrate(&models.Task{}); err != nil {
-        return nil, fmt.Errorf("failed to run migrations: %w", err)
-    }
 
-	// Check if the tasks table is already distributed
-	var count int64
-	if err := p.db.Raw("SELECT count(*) FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass").Scan(&count).Error; err != nil {
-		log.Printf("failed to check if tasks table is distributed: %v", err)
+	// Enable Citus extension
+	if err := p.db.Exec("CREATE EXTENSION IF NOT EXISTS citus").Error; err != nil {
+		return nil, err
 	}
 
-	if count == 0 {
-		// Distribute the tasks table
-		if err := p.db.Exec("SELECT create_distributed_table('tasks', 'id')").Error; err != nil {
-			return nil, fmt.Errorf("failed to distribute tasks table: %w", err)
+	// A worker that is down doesn't keep the service from starting; the
+	// coordinator keeps serving from the nodes it knows.
+	for _, worker := range cfg.Database.Citus.Workers {
+		added, err := p.AddCitusNode(worker)
+		if err != nil {
+			log.Printf("Failed to add worker node %s: %v", worker, err)
+		} else if added {
+			log.Printf("Added worker node %s", worker)
 		}
-		log.Println("tasks table is now distributed")
-	} else {
-		log.Println("tasks table is already distributed")
 	}
 
 	return p.db, nil
 }
 
-func (p *PostgresDB) Close() error {
-	sqlDB, err := p.db.DB()
-	if err != nil {
-		return err
+// Migrator returns a migrator for the opened database.
+func (p *PostgresDB) Migrator() (*Migrator, error) {
+	cfg := p.cfg
+	citus := cfg.Database.Citus
+	column, err := distributionColumn(citus)
+	if err != nil && citus.Enabled {
+		return nil, err
 	}
-	return sqlDB.Close()
+	return NewMigrator(p.db, citus.Enabled, MigrationParams{
+		SearchLanguage:     cfg.Search.Language,
+		DistributionColumn: column,
+		ShardCount:         citus.ShardCount,
+		ReplicationFactor:  citus.ReplicationFactor,
+	})
 }
 
-func (p *PostgresDB) CreateTask(task *models.Task) error {
-	return p.db.Create(task).Error
+// distributionColumn returns the column the tasks tables are distributed by.
+// Only "id" works: it is the whole primary key, which Citus requires to
+// contain the distribution column, and lookups by ID are routed to a single
+// shard by it.
+func distributionColumn(citus config.CitusConfig) (string, error) {
+	switch citus.DistributionColumn {
+	case "", "id":
+		return "id", nil
+	}
+	return "id", fmt.Errorf("database.citus.distribution_column is %q, but tasks can only be distributed by \"id\", their primary key",
+		citus.DistributionColumn)
 }
 
-func (p *PostgresDB) GetTaskByID(id string) (*models.Task, error) {
-	var task models.Task
-	err := p.db.First(&task, "id = ?", id).Error
-	return &task, err
+func (p *PostgresDB) Close() error {
+	for _, db := range append([]*gorm.DB{p.db}, p.replicas...) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (p *PostgresDB) GetAllTasksPaginated(page, pageSize int) ([]models.Task, error) {
-	var tasks []models.Task
-	offset := (page - 1) * pageSize
-	err := p.db.Limit(pageSize).Offset(offset).Find(&tasks).Error
-	return tasks, err
+func (p *PostgresDB) Tasks() repositories.Repository[models.Task] {
+	return repositories.NewTaskRepository(p.db, p.cfg, p.replicas...)
 }
 
-func (p *PostgresDB) UpdateTask(task *models.Task) error {
-	return p.db.Save(task).Error
+// openPostgres connects to the server at host and port with the credentials,
+// SSL and pool settings of cfg.
+func openPostgres(cfg config.DatabaseConfig, host string, port int) (*gorm.DB, error) {
+	sslMode := cfg.SSL.Mode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	params := []string{
+		"host", host,
+		"port", strconv.Itoa(port),
+		"user", cfg.User,
+		"password", cfg.Password,
+		"dbname", cfg.Name,
+		"sslmode", sslMode,
+		"sslrootcert", cfg.SSL.RootCert,
+		"sslcert", cfg.SSL.Cert,
+		"sslkey", cfg.SSL.Key,
+	}
+	var dsn []string
+	for i := 0; i < len(params); i += 2 {
+		if params[i+1] != "" {
+			dsn = append(dsn, params[i]+"="+quoteDSN(params[i+1]))
+		}
+	}
+
+	db, err := gorm.Open(postgres.Open(strings.Join(dsn, " ")), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.Pool.MaxOpenConns)
+	}
+	if cfg.Pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.Pool.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(cfg.Pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.Pool.ConnMaxIdleTime)
+	return db, nil
 }
 
-func (p *PostgresDB) DeleteTask(id string) error {
-	return p.db.Delete(&models.Task{}, "id = ?", id).Error
+// quoteDSN quotes a value of a key/value connection string, so passwords
+// and paths may contain spaces and quotes.
+func quoteDSN(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
 }
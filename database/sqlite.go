@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/drive-deep/task-microservice/config"
+	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/repositories"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// defaultSQLitePath is the database file used when none is configured.
+const defaultSQLitePath = "tasks.db"
+
+// sqliteArchiveSchema creates the table of archived tasks, as migration 0004
+// does on PostgreSQL.
+const sqliteArchiveSchema = `CREATE TABLE IF NOT EXISTS tasks_archive (
+	id text PRIMARY KEY,
+	title varchar(100),
+	description text,
+	status varchar(20),
+	priority integer,
+	created_at timestamp,
+	updated_at timestamp,
+	archived_at timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_status_updated_at ON tasks (status, updated_at);`
+
+// sqliteJobsSchema creates the table of background jobs, as migration 0005
+// does on PostgreSQL.
+const sqliteJobsSchema = `CREATE TABLE IF NOT EXISTS jobs (
+	id text PRIMARY KEY,
+	kind text NOT NULL,
+	state text NOT NULL,
+	total integer NOT NULL DEFAULT 0,
+	done integer NOT NULL DEFAULT 0,
+	result text,
+	error text,
+	started_at timestamp NOT NULL,
+	updated_at timestamp NOT NULL,
+	finished_at timestamp
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_state_updated_at ON jobs (state, updated_at);`
+
+// SQLiteDB keeps tasks in a single SQLite file, for running the service
+// locally without PostgreSQL.
+type SQLiteDB struct {
+	cfg *config.Config
+	db  *gorm.DB
+}
+
+func NewSQLiteDB(cfg *config.Config) *SQLiteDB {
+	return &SQLiteDB{cfg: cfg}
+}
+
+// Connect opens the database file, creating it if needed. The versioned
+// migrations are written for PostgreSQL, so the schema is created from the
+// models instead.
+func (s *SQLiteDB) Connect() (*gorm.DB, error) {
+	path := s.cfg.Database.Path
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	// WAL lets reads proceed during a write, and the busy timeout makes
+	// concurrent writers wait for each other instead of failing.
+	pragmas := url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "foreign_keys(1)"}}
+	var err error
+	s.db, err = gorm.Open(sqlite.Open(path+"?"+pragmas.Encode()), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.AutoMigrate(&models.Task{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	for _, schema := range []string{sqliteArchiveSchema, sqliteJobsSchema} {
+		if err := s.db.Exec(schema).Error; err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	return s.db, nil
+}
+
+func (s *SQLiteDB) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (s *SQLiteDB) Tasks() repositories.Repository[models.Task] {
+	return repositories.NewSQLiteTaskRepository(s.db, s.cfg.Database)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/repositories"
+	"github.com/drive-deep/task-microservice/services"
+)
+
+// batchResponse is the body of POST /tasks:batch.
+type batchResponse struct {
+	Mode    string        `json:"mode"`
+	Results []batchResult `json:"results"`
+}
+
+// batchResult reports an operation with the status it would have had as a
+// single request.
+type batchResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	ID     string       `json:"id,omitempty"`
+	Status int          `json:"status"`
+	Task   *models.Task `json:"task,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// BatchTasks applies a batch of creates, updates and deletes. It responds
+// 200 when every operation succeeded and 207 with the status of each
+// operation otherwise.
+func (h *TaskHandler) BatchTasks(w http.ResponseWriter, r *http.Request) {
+	var req services.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = services.BatchAtomic
+	}
+
+	results, err := h.Service.ApplyBatch(r.Context(), req)
+	if errors.Is(err, services.ErrInvalidOperation) || errors.Is(err, services.ErrBatchTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, services.ErrBatchUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	resp := batchResponse{Mode: req.Mode, Results: make([]batchResult, len(results))}
+	status := http.StatusOK
+	for i, res := range results {
+		item := batchResult{Index: i, Op: res.Op, ID: res.ID, Status: batchStatus(res)}
+		if res.Err != nil {
+			item.Error = res.Err.Error()
+			status = http.StatusMultiStatus
+		} else {
+			item.Task = res.Task
+		}
+		resp.Results[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchStatus returns the status of a batch operation.
+func batchStatus(res services.BatchResult) int {
+	switch {
+	case res.Err == nil && res.Op == repositories.BatchCreate:
+		return http.StatusCreated
+	case res.Err == nil && res.Op == repositories.BatchDelete:
+		return http.StatusNoContent
+	case res.Err == nil:
+		return http.StatusOK
+	case errors.Is(res.Err, services.ErrInvalidOperation):
+		return http.StatusBadRequest
+	case errors.Is(res.Err, services.ErrNotApplied):
+		return http.StatusFailedDependency
+	}
+	return serviceStatus(res.Err, http.StatusInternalServerError)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drive-deep/task-microservice/services"
+	"github.com/gorilla/mux"
+)
+
+// jobResponse is the representation of a background job.
+type jobResponse struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+	Total int64  `json:"total"`
+	Done  int64  `json:"done"`
+	// Progress is the fraction of the work done, from 0 to 1.
+	Progress   float64     `json:"progress"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+func newJobResponse(job services.Job) jobResponse {
+	resp := jobResponse{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		State:     job.State,
+		Total:     job.Total,
+		Done:      job.Done,
+		StartedAt: job.StartedAt,
+	}
+	if job.Total > 0 {
+		resp.Progress = min(float64(job.Done)/float64(job.Total), 1)
+	}
+	if job.State == services.JobSucceeded {
+		resp.Progress = 1
+	}
+	if !job.FinishedAt.IsZero() {
+		resp.FinishedAt = &job.FinishedAt
+	}
+	if job.Err != nil {
+		resp.Error = job.Err.Error()
+	}
+	switch result := job.Result.(type) {
+	case services.UpdateWhereResult:
+		resp.Result = newUpdateWhereResponse(result, false)
+	case services.ImportResult:
+		resp.Result = newImportResponse(result, false)
+	case nil:
+	default:
+		resp.Result = result
+	}
+	return resp
+}
+
+// GetJob reports the state of a background job.
+func (h *TaskHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.Service.GetJob(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
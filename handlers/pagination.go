@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/drive-deep/task-microservice/config"
+)
+
+const (
+	countModeExact     = "exact"
+	countModeEstimated = "estimated"
+)
+
+// parsePagination reads page and page_size from query, falling back to the
+// configured defaults and capping page_size at the configured maximum.
+func parsePagination(cfg *config.Config, query url.Values) (int, int, error) {
+	page := cfg.Server.Page
+	pageSize := cfg.Server.PageSize
+
+	var err error
+	if p := query.Get("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil {
+			return 0, 0, errors.New("Invalid page")
+		}
+	}
+	if ps := query.Get("page_size"); ps != "" {
+		if pageSize, err = strconv.Atoi(ps); err != nil {
+			return 0, 0, errors.New("Invalid page_size")
+		}
+	}
+
+	if page < 1 {
+		return 0, 0, errors.New("Invalid page")
+	}
+	if pageSize < 1 {
+		return 0, 0, errors.New("Invalid page_size")
+	}
+	if cfg.Server.MaxPageSize > 0 && pageSize > cfg.Server.MaxPageSize {
+		pageSize = cfg.Server.MaxPageSize
+	}
+	return page, pageSize, nil
+}
+
+// PageLinks holds the navigation links of a paginated response.
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Page is the envelope returned by paginated listings.
+type Page[T any] struct {
+	Items          []T       `json:"items"`
+	Page           int       `json:"page"`
+	PageSize       int       `json:"page_size"`
+	Total          int64     `json:"total"`
+	TotalEstimated bool      `json:"total_estimated,omitempty"`
+	Links          PageLinks `json:"links"`
+}
+
+func newPage[T any](r *http.Request, items []T, page, pageSize int, total int64, estimated bool) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	links := PageLinks{Self: pageURL(r.URL, page, pageSize)}
+	if page > 1 {
+		links.Prev = pageURL(r.URL, page-1, pageSize)
+	}
+
+	// An estimated total may be off in either direction, so a full page is
+	// taken as a hint that there is more to fetch.
+	hasNext := int64(page)*int64(pageSize) < total
+	if estimated {
+		hasNext = len(items) == pageSize
+	}
+	if hasNext {
+		links.Next = pageURL(r.URL, page+1, pageSize)
+	}
+
+	return Page[T]{
+		Items:          items,
+		Page:           page,
+		PageSize:       pageSize,
+		Total:          total,
+		TotalEstimated: estimated,
+		Links:          links,
+	}
+}
+
+// writeHeaders sets X-Total-Count and an RFC 8288 Link header for the page.
+func (p Page[T]) writeHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(p.Total, 10))
+
+	var links []string
+	if p.Links.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, p.Links.Next))
+	}
+	if p.Links.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, p.Links.Prev))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="self"`, p.Links.Self))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func pageURL(u *url.URL, page, pageSize int) string {
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("page_size", strconv.Itoa(pageSize))
+	return u.Path + "?" + query.Encode()
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/drive-deep/task-microservice/query"
+)
+
+// expanders embed related resources into task representations, keyed by the
+// name accepted by ?expand=. Tasks have no related resources yet; new ones
+// register here.
+var expanders = map[string]func(items []map[string]interface{}) error{}
+
+// representation describes how tasks are rendered: an optional sparse
+// fieldset from ?fields= and the related resources to embed from ?expand=.
+type representation struct {
+	fields query.Fields
+	expand []string
+}
+
+func parseRepresentation(params url.Values) (representation, error) {
+	var rep representation
+	if f := params.Get("fields"); f != "" {
+		fields, err := query.ParseFields(f, query.TaskSchema)
+		if err != nil {
+			return rep, fmt.Errorf("Invalid fields: %w", err)
+		}
+		rep.fields = fields
+	}
+	if e := params.Get("expand"); e != "" {
+		for _, name := range strings.Split(e, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := expanders[name]; !ok {
+				return rep, fmt.Errorf("Invalid expand: unknown relation %q", name)
+			}
+			rep.expand = append(rep.expand, name)
+		}
+	}
+	return rep, nil
+}
+
+// custom reports whether the default task representation can't be used.
+func (rep representation) custom() bool {
+	return len(rep.fields) > 0 || len(rep.expand) > 0
+}
+
+// render projects every item onto the fieldset and embeds the expansions.
+func render[T any](rep representation, items []T) ([]map[string]interface{}, error) {
+	rendered := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		projected, err := rep.fields.Project(item)
+		if err != nil {
+			return nil, err
+		}
+		rendered[i] = projected
+	}
+	for _, name := range rep.expand {
+		if err := expanders[name](rendered); err != nil {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
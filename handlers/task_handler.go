@@ -1,23 +1,34 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/query"
+	"github.com/drive-deep/task-microservice/repositories"
+	"github.com/drive-deep/task-microservice/resilience"
 	"github.com/drive-deep/task-microservice/services"
 	"github.com/gorilla/mux"
 )
 
 type TaskHandler struct {
 	Service services.TaskService
+	// cfg is loaded once at startup; later edits of the file take effect
+	// on restart.
+	cfg *config.Config
 }
 
-func NewTaskHandler(service services.TaskService) *TaskHandler {
-	return &TaskHandler{Service: service}
+func NewTaskHandler(service services.TaskService, cfg *config.Config) *TaskHandler {
+	return &TaskHandler{Service: service, cfg: cfg}
 }
 
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
@@ -28,9 +39,9 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Service.CreateTask(&task)
+	err = h.Service.CreateTask(r.Context(), &task)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -46,9 +57,25 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task, err := h.Service.GetTaskByID(id)
+	rep, err := parseRepresentation(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.Service.GetTaskByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusNotFound))
+		return
+	}
+
+	if rep.custom() {
+		rendered, err := render(rep, []*models.Task{task})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rendered[0])
 		return
 	}
 
@@ -56,62 +83,224 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *TaskHandler) GetAllTasks(w http.ResponseWriter, r *http.Request) {
-	cfg, err := config.LoadConfig()
+	cfg := h.cfg
+	params := r.URL.Query()
+
+	page, pageSize, err := parsePagination(cfg, params)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	query := r.URL.Query()
 
-	// Pagination parameters
-	page := cfg.Server.Page
-	pageSize := cfg.Server.PageSize
+	countMode := cfg.Server.CountMode
+	if c := params.Get("count"); c != "" {
+		countMode = c
+	}
+	if countMode == "" {
+		countMode = countModeExact
+	}
+	if countMode != countModeExact && countMode != countModeEstimated {
+		http.Error(w, "Invalid count", http.StatusBadRequest)
+		return
+	}
 
-	if p := query.Get("page"); p != "" {
-		page, err = strconv.Atoi(p)
-		if err != nil {
-			http.Error(w, "Invalid page", http.StatusBadRequest)
-			return
-		}
+	// Sorting parameters
+	sortBy := params.Get("sort_by")
+	if sortBy == "" {
+		sortBy = "updated_at"
+	}
+	sort, err := query.ParseSort(sortBy, params.Get("order"), query.TaskSchema)
+	if err != nil {
+		http.Error(w, "Invalid sort: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseFilter(params, listParams...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rep, err := parseRepresentation(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	includeArchived, err := parseIncludeArchived(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := h.Service.GetAllTasks(r.Context(), repositories.ListOptions{
+		Filter:          filter,
+		Sort:            sort,
+		Fields:          rep.fields,
+		Page:            page,
+		PageSize:        pageSize,
+		IncludeArchived: includeArchived,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	estimate := countMode == countModeEstimated
+	total, err := h.Service.CountTasks(r.Context(), filter, estimate, includeArchived)
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
 	}
 
-	if ps := query.Get("page_size"); ps != "" {
-		pageSize, err = strconv.Atoi(ps)
+	estimated := estimate && filter == nil && !includeArchived
+	if rep.custom() {
+		items, err := render(rep, tasks)
 		if err != nil {
-			http.Error(w, "Invalid page_size", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		result := newPage(r, items, page, pageSize, total, estimated)
+		result.writeHeaders(w)
+		json.NewEncoder(w).Encode(result)
+		return
 	}
 
-	// Sorting parameters
-	sortBy := query.Get("sort_by")
-	if sortBy == "" {
-		sortBy = "updated_at asc"
+	result := newPage(r, tasks, page, pageSize, total, estimated)
+	result.writeHeaders(w)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *TaskHandler) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	cfg := h.cfg
+	params := r.URL.Query()
+
+	text := strings.TrimSpace(params.Get("q"))
+	if text == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+
+	page, pageSize, err := parsePagination(cfg, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	order := query.Get("order")
-	if sortBy != "" && order != "" {
-		if order != "asc" && order != "desc" {
-			http.Error(w, "Invalid order", http.StatusBadRequest)
+
+	language := cfg.Search.Language
+	if l := params.Get("lang"); l != "" {
+		if !slices.Contains(cfg.Search.Languages, l) && l != cfg.Search.Language {
+			http.Error(w, "Invalid lang", http.StatusBadRequest)
 			return
 		}
-		sortBy = sortBy + " " + order
+		language = l
 	}
 
-	// Filtering parameters
-	filter := make(map[string]interface{})
-	if status := query.Get("status"); status != "" {
-		filter["status"] = status
+	prefix := true
+	if p := params.Get("prefix"); p != "" {
+		prefix, err = strconv.ParseBool(p)
+		if err != nil {
+			http.Error(w, "Invalid prefix", http.StatusBadRequest)
+			return
+		}
 	}
-	if priority := query.Get("priority"); priority != "" {
-		filter["priority"] = priority
+
+	filter, err := parseFilter(params, searchParams...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	tasks, err := h.Service.GetAllTasks(filter, sortBy, page, pageSize)
+	results, total, err := h.Service.SearchTasks(r.Context(), repositories.SearchQuery{
+		Text:     text,
+		Language: language,
+		Prefix:   prefix,
+		Filter:   filter,
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if errors.Is(err, services.ErrSearchUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	json.NewEncoder(w).Encode(tasks)
+	result := newPage(r, results, page, pageSize, total, false)
+	result.writeHeaders(w)
+	json.NewEncoder(w).Encode(result)
+}
+
+var (
+	// listParams are the query parameters of GET /tasks that are not
+	// field filters.
+	listParams = []string{"page", "page_size", "count", "sort_by", "order", "filter", "fields", "expand", "include_archived"}
+	// searchParams are the query parameters of GET /tasks/search that are
+	// not field filters.
+	searchParams = []string{"page", "page_size", "q", "lang", "prefix", "filter"}
+)
+
+// parseIncludeArchived reads the include_archived parameter, false by
+// default.
+func parseIncludeArchived(params url.Values) (bool, error) {
+	v := params.Get("include_archived")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("Invalid include_archived")
+	}
+	return include, nil
+}
+
+// parseFilter combines the filter expression with equality filters given as
+// <field>=<value> parameters, where field must be in the task schema. Repeated
+// parameters match any of their values. Parameters listed in reserved are
+// skipped. It returns nil when no filter was given.
+func parseFilter(values url.Values, reserved ...string) (query.Expr, error) {
+	var exprs []query.Expr
+	if f := values.Get("filter"); f != "" {
+		expr, err := query.Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter: %w", err)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !slices.Contains(reserved, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if _, ok := query.TaskSchema[key]; !ok {
+			return nil, fmt.Errorf("Invalid filter: unknown field %q", key)
+		}
+		var fieldValues []string
+		for _, v := range values[key] {
+			if v != "" {
+				fieldValues = append(fieldValues, v)
+			}
+		}
+		if len(fieldValues) > 0 {
+			exprs = append(exprs, query.In(key, fieldValues...))
+		}
+	}
+
+	filter := query.And(exprs...)
+	if filter == nil {
+		return nil, nil
+	}
+	if err := query.Validate(filter, query.TaskSchema); err != nil {
+		return nil, fmt.Errorf("Invalid filter: %w", err)
+	}
+	return filter, nil
 }
 
 func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
@@ -121,10 +310,12 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// The task updated is the one of the URL, whatever the body says.
+	task.ID = mux.Vars(r)["id"]
 
-	err := h.Service.UpdateTask(&task)
+	err := h.Service.UpdateTask(r.Context(), &task)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -139,10 +330,27 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Service.DeleteTask(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.Service.DeleteTask(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// serviceStatus returns the status of a failed task service call: 404 for
+// missing tasks, 409 for writes to archived ones, 504 when a dependency timed
+// out, 503 when its circuit breaker is open, fallback otherwise.
+func serviceStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repositories.ErrArchived):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, resilience.ErrOpen):
+		return http.StatusServiceUnavailable
+	}
+	return fallback
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/drive-deep/task-microservice/services"
+	"github.com/drive-deep/task-microservice/taskio"
+)
+
+var (
+	// exportParams are the query parameters of GET /tasks/export that are
+	// not field filters.
+	exportParams = []string{"format", "filter", "include_archived"}
+)
+
+type importResponse struct {
+	DryRun  bool          `json:"dry_run,omitempty"`
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []importError `json:"errors,omitempty"`
+	Job     *jobResponse  `json:"job,omitempty"`
+}
+
+type importError struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// ExportTasks streams the tasks matching the filters of GET /tasks as CSV or
+// NDJSON.
+func (h *TaskHandler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = taskio.FormatNDJSON
+	}
+	if !taskio.Valid(format) {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(params, exportParams...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeArchived, err := parseIncludeArchived(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out, err := taskio.NewWriter(w, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", taskio.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks.`+format+`"`)
+	flusher, _ := w.(http.Flusher)
+	started := false
+	err = h.Service.ExportTasks(r.Context(), filter, includeArchived, func(tasks []services.Task) error {
+		started = true
+		for _, task := range tasks {
+			if err := out.Write(task); err != nil {
+				return err
+			}
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+	if err != nil {
+		// The status was sent, so the connection is dropped to keep clients
+		// from taking a partial export for a complete one.
+		log.Printf("Failed to export tasks: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Failed to export tasks: %v", err)
+	}
+}
+
+// ImportTasks loads tasks from a CSV or NDJSON body and reports the tasks
+// that failed. Large imports respond 202 with a job to poll at the Location.
+func (h *TaskHandler) ImportTasks(w http.ResponseWriter, r *http.Request) {
+	cfg := h.cfg
+	params := r.URL.Query()
+
+	format := params.Get("format")
+	if format == "" {
+		format = taskio.FormatOf(r.Header.Get("Content-Type"))
+	}
+	if !taskio.Valid(format) {
+		http.Error(w, "Invalid format, expected csv or ndjson", http.StatusBadRequest)
+		return
+	}
+	mapping, err := taskio.ParseMapping(params["map"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var opts services.ImportOptions
+	for name, value := range map[string]*bool{"upsert": &opts.Upsert, "dry_run": &opts.DryRun} {
+		if v := params.Get(name); v != "" {
+			if *value, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	body := r.Body
+	if cfg.Import.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, cfg.Import.MaxBytes)
+	}
+	records, err := taskio.ReadAll(body, format, mapping, cfg.Import.MaxRows)
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, taskio.ErrTooManyRecords) || errors.As(err, &maxBytesErr) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Service.ImportTasks(r.Context(), records, opts)
+	if err != nil {
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := newImportResponse(result, opts.DryRun)
+	if result.Job != nil {
+		job := newJobResponse(*result.Job)
+		resp.Job = &job
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newImportResponse(result services.ImportResult, dryRun bool) importResponse {
+	resp := importResponse{
+		DryRun:  dryRun,
+		Rows:    result.Rows,
+		Created: result.Created,
+		Updated: result.Updated,
+		Failed:  result.Failed,
+	}
+	for _, e := range result.Errors {
+		resp.Errors = append(resp.Errors, importError{Line: e.Line, ID: e.ID, Error: e.Err.Error()})
+	}
+	return resp
+}
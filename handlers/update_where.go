@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/drive-deep/task-microservice/query"
+	"github.com/drive-deep/task-microservice/services"
+)
+
+// readOnlyFields are the task fields a bulk update may not change.
+var readOnlyFields = []string{"id", "created_at", "updated_at"}
+
+// updateWhereRequest is the body of POST /tasks:update-where. Filter uses the
+// syntax of the filter parameter of GET /tasks.
+type updateWhereRequest struct {
+	Filter string          `json:"filter"`
+	Patch  json.RawMessage `json:"patch"`
+	DryRun bool            `json:"dry_run"`
+}
+
+type updateWhereResponse struct {
+	DryRun    bool         `json:"dry_run,omitempty"`
+	Matched   int64        `json:"matched"`
+	Updated   *int64       `json:"updated,omitempty"`
+	SampleIDs []string     `json:"sample_ids,omitempty"`
+	Job       *jobResponse `json:"job,omitempty"`
+}
+
+// UpdateTasksWhere applies a patch to every task matching a filter. Large
+// updates respond 202 with a job to poll at the Location.
+func (h *TaskHandler) UpdateTasksWhere(w http.ResponseWriter, r *http.Request) {
+	var body updateWhereRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Filter == "" {
+		http.Error(w, "Missing filter", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(url.Values{"filter": {body.Filter}}, "filter")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Patch) == 0 {
+		http.Error(w, "Missing patch", http.StatusBadRequest)
+		return
+	}
+	patch, err := query.ParsePatch(body.Patch, query.TaskSchema, readOnlyFields...)
+	if err != nil {
+		http.Error(w, "Invalid patch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Service.UpdateWhere(r.Context(), services.UpdateWhereRequest{
+		Filter: filter,
+		Patch:  patch,
+		DryRun: body.DryRun,
+	})
+	switch {
+	case errors.Is(err, services.ErrInvalidOperation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrTooManyMatches):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, services.ErrBulkUpdateUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Job != nil {
+		job := newJobResponse(*result.Job)
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(updateWhereResponse{Matched: result.Matched, Job: &job})
+		return
+	}
+	json.NewEncoder(w).Encode(newUpdateWhereResponse(result, body.DryRun))
+}
+
+func newUpdateWhereResponse(result services.UpdateWhereResult, dryRun bool) updateWhereResponse {
+	resp := updateWhereResponse{DryRun: dryRun, Matched: result.Matched, SampleIDs: result.SampleIDs}
+	if !dryRun {
+		resp.Updated = &result.Updated
+	}
+	return resp
+}
@@ -3,7 +3,9 @@ package message_queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/drive-deep/task-microservice/services"
@@ -13,28 +15,40 @@ type KafkaMessageQueue struct {
 	producer      sarama.SyncProducer
 	consumerGroup sarama.ConsumerGroup
 	taskService   *services.TaskService
+	// timeout bounds sending a message and processing a consumed one.
+	timeout time.Duration
 }
 
-func NewKafkaMessageQueue(taskService *services.TaskService) *KafkaMessageQueue {
+// NewKafkaMessageQueue returns a queue whose messages are applied by
+// taskService, each within timeout; zero for none.
+func NewKafkaMessageQueue(taskService *services.TaskService, timeout time.Duration) *KafkaMessageQueue {
 	return &KafkaMessageQueue{
 		taskService: taskService,
+		timeout:     timeout,
 	}
 }
 
 func (kmq *KafkaMessageQueue) Connect(brokers []string, groupID string) (MessageQueue, error) {
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = 5
-	config.Producer.Return.Successes = true
-	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Retry.Max = 5
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if kmq.timeout > 0 {
+		// The sync producer takes no context, so the timeout is enforced by
+		// the broker acknowledgement and network deadlines.
+		saramaConfig.Producer.Timeout = kmq.timeout
+		saramaConfig.Net.WriteTimeout = kmq.timeout
+		saramaConfig.Net.ReadTimeout = kmq.timeout
+	}
+
+	producer, err := sarama.NewSyncProducer(brokers, saramaConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupID, saramaConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +58,10 @@ func (kmq *KafkaMessageQueue) Connect(brokers []string, groupID string) (Message
 	return kmq, nil
 }
 
-func (kmq *KafkaMessageQueue) SendMessage(topic string, message []byte) error {
+func (kmq *KafkaMessageQueue) SendMessage(ctx context.Context, topic string, message []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(message),
@@ -59,19 +76,23 @@ func (kmq *KafkaMessageQueue) SendMessage(topic string, message []byte) error {
 	return nil
 }
 
-func (kmq *KafkaMessageQueue) StartConsuming(topics []string) {
+func (kmq *KafkaMessageQueue) StartConsuming(ctx context.Context, topics []string) {
 	consumer := KafkaConsumer{
 		ready:       make(chan bool),
 		taskService: kmq.taskService,
+		timeout:     kmq.timeout,
 	}
 
 	go func() {
 		for {
-			if err := kmq.consumerGroup.Consume(context.Background(), topics, &consumer); err != nil {
+			if err := kmq.consumerGroup.Consume(ctx, topics, &consumer); err != nil {
+				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+					return
+				}
 				log.Printf("Error from consumer: %v", err)
 			}
 			// Check if context was cancelled, signaling that the consumer should stop
-			if context.Background().Err() != nil {
+			if ctx.Err() != nil {
 				return
 			}
 			consumer.ready = make(chan bool)
@@ -92,6 +113,7 @@ func (kmq *KafkaMessageQueue) Close() error {
 type KafkaConsumer struct {
 	ready       chan bool
 	taskService *services.TaskService
+	timeout     time.Duration
 }
 
 func (consumer *KafkaConsumer) Setup(_ sarama.ConsumerGroupSession) error {
@@ -106,52 +128,83 @@ func (consumer *KafkaConsumer) Cleanup(_ sarama.ConsumerGroupSession) error {
 func (consumer *KafkaConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
 		log.Printf("Message claimed: value = %s, timestamp = %v, topic = %s", string(message.Value), message.Timestamp, message.Topic)
-		switch message.Topic {
-		case "task_create":
-			consumer.handleTaskCreate(message.Value)
-		case "task_update":
-			consumer.handleTaskUpdate(message.Value)
-		case "task_delete":
-			consumer.handleTaskDelete(message.Value)
-		}
+		consumer.handle(sess.Context(), message)
 		sess.MarkMessage(message, "")
 	}
 	return nil
 }
 
-func (consumer *KafkaConsumer) handleTaskCreate(message []byte) {
+// handle processes message, giving up after the configured timeout or when
+// the session ends, e.g. because partitions are rebalanced.
+func (consumer *KafkaConsumer) handle(ctx context.Context, message *sarama.ConsumerMessage) {
+	if consumer.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, consumer.timeout)
+		defer cancel()
+	}
+	switch message.Topic {
+	case "task_create":
+		consumer.handleTaskCreate(ctx, message.Value)
+	case "task_update":
+		consumer.handleTaskUpdate(ctx, message.Value)
+	case "task_delete":
+		consumer.handleTaskDelete(ctx, message.Value)
+	case "task_batch":
+		consumer.handleTaskBatch(ctx, message.Value)
+	}
+}
+
+func (consumer *KafkaConsumer) handleTaskCreate(ctx context.Context, message []byte) {
 	var task services.Task
 	if err := json.Unmarshal(message, &task); err != nil {
 		log.Printf("Failed to unmarshal task create message: %v", err)
 		return
 	}
 
-	if err := consumer.taskService.CreateTask(&task); err != nil {
+	if err := consumer.taskService.CreateTask(ctx, &task); err != nil {
 		log.Printf("Failed to create task: %v", err)
 	}
 }
 
-func (consumer *KafkaConsumer) handleTaskUpdate(message []byte) {
+func (consumer *KafkaConsumer) handleTaskUpdate(ctx context.Context, message []byte) {
 	var task services.Task
     if err := json.Unmarshal(message, &task); err != nil {
         log.Printf("Failed to unmarshal task create message: %v", err)
         return
     }
 
-    if err := consumer.taskService.UpdateTask(&task); err != nil {
+    if err := consumer.taskService.UpdateTask(ctx, &task); err != nil {
         log.Printf("Failed to create task: %v", err)
     }
 }
 
-func (consumer *KafkaConsumer) handleTaskDelete(message []byte) {
+func (consumer *KafkaConsumer) handleTaskDelete(ctx context.Context, message []byte) {
 	var task services.Task
 	if err := json.Unmarshal(message, &task); err != nil {
 		log.Printf("Failed to unmarshal task create message: %v", err)
 		return
 	}
 
-	if err := consumer.taskService.DeleteTask(task.ID); err != nil {
+	if err := consumer.taskService.DeleteTask(ctx, task.ID); err != nil {
 		log.Printf("Failed to create task: %v", err)
 	}
 }
 
+func (consumer *KafkaConsumer) handleTaskBatch(ctx context.Context, message []byte) {
+	var batch services.BatchRequest
+	if err := json.Unmarshal(message, &batch); err != nil {
+		log.Printf("Failed to unmarshal task batch message: %v", err)
+		return
+	}
+
+	results, err := consumer.taskService.ApplyBatch(ctx, batch)
+	if err != nil {
+		log.Printf("Failed to apply task batch: %v", err)
+		return
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			log.Printf("Failed to apply operation %d (%s %s) of task batch: %v", i, result.Op, result.ID, result.Err)
+		}
+	}
+}
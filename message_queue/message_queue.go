@@ -1,8 +1,10 @@
 package message_queue
 
+import "context"
+
 type MessageQueue interface {
     Connect(brokers []string, groupID string) (MessageQueue, error)
-    SendMessage(topic string, message []byte) error
-    StartConsuming(topics []string)
+    SendMessage(ctx context.Context, topic string, message []byte) error
+    StartConsuming(ctx context.Context, topics []string)
     Close() error
 }
\ No newline at end of file
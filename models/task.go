@@ -11,4 +11,16 @@ type Task struct {
     Priority    int       `json:"priority" gorm:"type:int"`
     CreatedAt   time.Time `json:"created_at" gorm:"type:timestamp;default:current_timestamp;autoCreateTime"`
     UpdatedAt   time.Time `json:"updated_at" gorm:"type:timestamp;default:current_timestamp;autoUpdateTime"`
+    // ArchivedAt is set on tasks read from the tasks_archive table. It is
+    // never written with the task; archiving moves the row.
+    ArchivedAt *time.Time `json:"archived_at,omitempty" gorm:"->;-:migration"`
+}
+
+// TaskSearchResult is a task matched by a full-text search, with its rank and
+// highlighted title and description fragments
+type TaskSearchResult struct {
+    Task
+    Rank                 float64 `json:"rank"`
+    TitleHighlight       string  `json:"title_highlight"`
+    DescriptionHighlight string  `json:"description_highlight"`
 }
\ No newline at end of file
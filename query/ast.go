@@ -0,0 +1,154 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operator is a comparison operator of the filter language.
+type Operator string
+
+const (
+	OpEq       Operator = "="
+	OpNeq      Operator = "!="
+	OpLt       Operator = "<"
+	OpLte      Operator = "<="
+	OpGt       Operator = ">"
+	OpGte      Operator = ">="
+	OpContains Operator = "~"
+	OpExcludes Operator = "!~"
+	OpIn       Operator = "in"
+	OpNotIn    Operator = "not in"
+)
+
+// Expr is a node of a parsed filter expression.
+type Expr interface {
+	// Pos is the 1-based position of the node in the filter source, or 0 for
+	// nodes built in code.
+	Pos() int
+	String() string
+}
+
+// Logical combines two expressions with "and" or "or".
+type Logical struct {
+	Op          string
+	Left, Right Expr
+	pos         int
+}
+
+// Not negates an expression.
+type Not struct {
+	X   Expr
+	pos int
+}
+
+// Comparison compares a field with one value, or a list of values for the
+// "in" operators.
+type Comparison struct {
+	Field  string
+	Op     Operator
+	Values []Value
+	pos    int
+	opPos  int
+}
+
+// Value is a literal as written in the filter, before it is typed against the
+// schema.
+type Value struct {
+	Raw string
+	Pos int
+}
+
+func (e *Logical) Pos() int    { return e.pos }
+func (e *Not) Pos() int        { return e.pos }
+func (e *Comparison) Pos() int { return e.pos }
+
+func (e *Logical) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+func (e *Not) String() string {
+	return fmt.Sprintf("not %s", e.X)
+}
+
+func (e *Comparison) String() string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = fmt.Sprintf("%q", v.Raw)
+	}
+	if e.Op == OpIn || e.Op == OpNotIn {
+		return fmt.Sprintf("%s %s (%s)", e.Field, e.Op, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", e.Field, e.Op, values[0])
+}
+
+// Equal builds a field = value comparison.
+func Equal(field, value string) Expr {
+	return &Comparison{Field: field, Op: OpEq, Values: []Value{{Raw: value}}}
+}
+
+// In builds a comparison matching any of values. A single value gives a
+// plain field = value comparison.
+func In(field string, values ...string) Expr {
+	if len(values) == 1 {
+		return Equal(field, values[0])
+	}
+	cmp := &Comparison{Field: field, Op: OpIn}
+	for _, v := range values {
+		cmp.Values = append(cmp.Values, Value{Raw: v})
+	}
+	return cmp
+}
+
+// Greater builds a field > value comparison.
+func Greater(field, value string) Expr {
+	return &Comparison{Field: field, Op: OpGt, Values: []Value{{Raw: value}}}
+}
+
+// And joins the non-nil expressions with "and". It returns nil when there is
+// nothing to join.
+func And(exprs ...Expr) Expr {
+	var result Expr
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		if result == nil {
+			result = e
+		} else {
+			result = &Logical{Op: "and", Left: result, Right: e, pos: result.Pos()}
+		}
+	}
+	return result
+}
+
+// Equalities reports the values each field is required to equal when e is a
+// conjunction of "=" and "in" comparisons on distinct fields, such as
+// `status = todo and priority in (1, 2)`. It returns false for any other
+// expression.
+func Equalities(e Expr) (map[string][]string, bool) {
+	eq := make(map[string][]string)
+	var walk func(e Expr) bool
+	walk = func(e Expr) bool {
+		switch e := e.(type) {
+		case *Logical:
+			return e.Op == "and" && walk(e.Left) && walk(e.Right)
+		case *Comparison:
+			if e.Op != OpEq && e.Op != OpIn {
+				return false
+			}
+			if _, dup := eq[e.Field]; dup {
+				return false
+			}
+			for _, v := range e.Values {
+				eq[e.Field] = append(eq[e.Field], v.Raw)
+			}
+			return true
+		}
+		return false
+	}
+	if e == nil || !walk(e) {
+		return nil, false
+	}
+	return eq, true
+}
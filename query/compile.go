@@ -0,0 +1,140 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Compile checks expr against the schema and turns it into a parameterised
+// GORM condition. Column names come from the schema and every value is bound
+// as a parameter, so no filter input is ever spliced into the SQL text.
+func Compile(expr Expr, s Schema) (clause.Expression, error) {
+	c := compiler{schema: s}
+	if err := c.compile(expr); err != nil {
+		return nil, err
+	}
+	return clause.Expr{SQL: c.sql.String(), Vars: c.vars}, nil
+}
+
+// Validate reports the first error Compile would return for expr.
+func Validate(expr Expr, s Schema) error {
+	_, err := Compile(expr, s)
+	return err
+}
+
+type compiler struct {
+	schema Schema
+	sql    strings.Builder
+	vars   []interface{}
+}
+
+func (c *compiler) compile(expr Expr) error {
+	switch e := expr.(type) {
+	case *Logical:
+		c.sql.WriteByte('(')
+		if err := c.compile(e.Left); err != nil {
+			return err
+		}
+		c.sql.WriteString(" " + strings.ToUpper(e.Op) + " ")
+		if err := c.compile(e.Right); err != nil {
+			return err
+		}
+		c.sql.WriteByte(')')
+		return nil
+
+	case *Not:
+		c.sql.WriteString("NOT (")
+		if err := c.compile(e.X); err != nil {
+			return err
+		}
+		c.sql.WriteByte(')')
+		return nil
+
+	case *Comparison:
+		return c.comparison(e)
+	}
+	return errorf(expr.Pos(), "unsupported expression %s", expr)
+}
+
+func (c *compiler) comparison(e *Comparison) error {
+	field, ok := c.schema[e.Field]
+	if !ok {
+		return errorf(e.pos, "unknown field %q", e.Field)
+	}
+
+	values := make([]interface{}, len(e.Values))
+	for i, v := range e.Values {
+		typed, err := typedValue(field, v)
+		if err != nil {
+			return err
+		}
+		values[i] = typed
+	}
+
+	column := clause.Column{Name: field.Column}
+	switch e.Op {
+	case OpEq, OpNeq, OpLt, OpLte, OpGt, OpGte:
+		op := string(e.Op)
+		if e.Op == OpNeq {
+			op = "<>"
+		}
+		c.sql.WriteString("? " + op + " ?")
+		c.vars = append(c.vars, column, values[0])
+
+	case OpContains, OpExcludes:
+		if field.Kind != KindString {
+			return errorf(e.opPos, "operator %q is only supported on text fields", e.Op)
+		}
+		if e.Op == OpExcludes {
+			c.sql.WriteString("NOT ")
+		}
+		c.sql.WriteString(`LOWER(?) LIKE ? ESCAPE '\'`)
+		c.vars = append(c.vars, column, "%"+escapeLike(strings.ToLower(values[0].(string)))+"%")
+
+	case OpIn, OpNotIn:
+		c.sql.WriteString("?")
+		if e.Op == OpNotIn {
+			c.sql.WriteString(" NOT")
+		}
+		c.sql.WriteString(" IN (")
+		c.vars = append(c.vars, column)
+		for i, v := range values {
+			if i > 0 {
+				c.sql.WriteString(",")
+			}
+			c.sql.WriteString("?")
+			c.vars = append(c.vars, v)
+		}
+		c.sql.WriteString(")")
+
+	default:
+		return errorf(e.opPos, "unknown operator %q", e.Op)
+	}
+	return nil
+}
+
+func typedValue(field Field, v Value) (interface{}, error) {
+	switch field.Kind {
+	case KindInt:
+		n, err := strconv.ParseInt(v.Raw, 10, 64)
+		if err != nil {
+			return nil, errorf(v.Pos, "invalid integer %q for field %q", v.Raw, field.Name)
+		}
+		return n, nil
+	case KindTime:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, v.Raw); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errorf(v.Pos, "invalid time %q for field %q, expected RFC 3339 or YYYY-MM-DD", v.Raw, field.Name)
+	}
+	return v.Raw, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
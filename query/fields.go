@@ -0,0 +1,63 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Fields is a sparse fieldset: the schema fields a client asked for, in the
+// order given.
+type Fields []Field
+
+// ParseFields parses a comma separated list of field names such as
+// "id,title,status". Every field must be in the schema.
+func ParseFields(spec string, s Schema) (Fields, error) {
+	var fields Fields
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		field, ok := s[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// Columns returns the database columns of the fieldset.
+func (f Fields) Columns() []string {
+	columns := make([]string, len(f))
+	for i, field := range f {
+		columns[i] = field.Column
+	}
+	return columns
+}
+
+// Project returns the JSON representation of v reduced to the fieldset. An
+// empty fieldset keeps every field.
+func (f Fields) Project(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	if len(f) == 0 {
+		return all, nil
+	}
+
+	projected := make(map[string]interface{}, len(f))
+	for _, field := range f {
+		if value, ok := all[field.Name]; ok {
+			projected[field.Name] = value
+		}
+	}
+	return projected, nil
+}
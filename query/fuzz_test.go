@@ -0,0 +1,209 @@
+package query
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/drive-deep/task-microservice/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dryRun renders statements for PostgreSQL without connecting to it.
+var dryRun = func() *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return db
+}()
+
+// whereTokens are the only tokens a compiled filter may render to besides
+// quoted schema columns: placeholders, operators and keywords.
+var whereTokens = regexp.MustCompile(`\$\d+|"[a-z_]+"|<>|<=|>=|[=<>(),]|\bAND\b|\bOR\b|\bNOT\b|\bIN\b|\bLOWER\b|\bLIKE\b|\bESCAPE\b|'\\'|\s+`)
+
+// placeholder matches the bound parameters of a rendered statement.
+var placeholder = regexp.MustCompile(`\$\d+`)
+
+// quotedColumn matches identifiers quoted by the dialect.
+var quotedColumn = regexp.MustCompile(`"([a-z_]+)"`)
+
+// render returns the WHERE clause the condition renders to for PostgreSQL and
+// the parameters bound to it.
+func render(t *testing.T, condition clause.Expression) (string, []interface{}) {
+	t.Helper()
+	stmt := dryRun.Session(&gorm.Session{}).Model(&models.Task{}).Where(condition).Find(&[]models.Task{}).Statement
+	sql := stmt.SQL.String()
+	_, where, ok := strings.Cut(sql, " WHERE ")
+	if !ok {
+		t.Fatalf("no WHERE clause in %q", sql)
+	}
+	return where, stmt.Vars
+}
+
+// checkWhere fails unless where holds only schema columns, placeholders,
+// operators and keywords, and binds exactly the literals of expr.
+func checkWhere(t *testing.T, expr Expr, where string, vars []interface{}) {
+	t.Helper()
+	if rest := whereTokens.ReplaceAllString(where, ""); rest != "" {
+		t.Fatalf("unexpected text %q in WHERE clause %q", rest, where)
+	}
+	columns := make(map[string]bool)
+	for _, f := range TaskSchema {
+		columns[f.Column] = true
+	}
+	for _, m := range quotedColumn.FindAllStringSubmatch(where, -1) {
+		if !columns[m[1]] {
+			t.Fatalf("column %q of WHERE clause %q is not in the schema", m[1], where)
+		}
+	}
+	if n := len(placeholder.FindAllString(where, -1)); n != len(vars) {
+		t.Fatalf("WHERE clause %q has %d placeholders for %d parameters", where, n, len(vars))
+	}
+	if want := bound(t, expr); !reflect.DeepEqual(vars, want) {
+		t.Fatalf("WHERE clause %q binds %#v for the literals %#v of %s", where, vars, want, expr)
+	}
+}
+
+// bound returns the parameters expr must compile to: its literals in order,
+// typed by their fields and turned into patterns by the text operators.
+func bound(t *testing.T, expr Expr) []interface{} {
+	switch e := expr.(type) {
+	case *Logical:
+		return append(bound(t, e.Left), bound(t, e.Right)...)
+	case *Not:
+		return bound(t, e.X)
+	case *Comparison:
+		var vars []interface{}
+		for _, v := range e.Values {
+			typed, err := typedValue(TaskSchema[e.Field], v)
+			if err != nil {
+				t.Fatalf("compiled %s with the invalid value %q", expr, v.Raw)
+			}
+			if e.Op == OpContains || e.Op == OpExcludes {
+				typed = "%" + escapeLike(strings.ToLower(v.Raw)) + "%"
+			}
+			vars = append(vars, typed)
+		}
+		return vars
+	}
+	t.Fatalf("unknown expression %T", expr)
+	return nil
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`status = todo`,
+		`priority>=2 and status in (todo,blocked) and title ~ "deploy"`,
+		`not (status = done or priority < 1) and created_at >= 2024-01-01`,
+		`title !~ '50%_off\'`,
+		`status not in ("a", 'b', c)`,
+		`title = "x'; DROP TABLE tasks; --"`,
+		`title = 'a" OR 1=1 --'`,
+		`"status" = todo`,
+		`id = $1`,
+		`description ~ "\\%"`,
+		`((((status = a))))`,
+		`status = todo and`,
+		`status in ()`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		expr, err := Parse(input)
+		if err != nil {
+			if expr != nil {
+				t.Fatalf("Parse(%q) returned an expression along with %v", input, err)
+			}
+			return
+		}
+		condition, err := Compile(expr, TaskSchema)
+		if err != nil {
+			return
+		}
+		where, vars := render(t, condition)
+		checkWhere(t, expr, where, vars)
+	})
+}
+
+func FuzzParseSort(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"updated_at", ""},
+		{"priority desc,created_at", "asc"},
+		{"-priority, title asc", "desc"},
+		{`title; DROP TABLE tasks`, ""},
+		{`"title"`, ""},
+		{"priority,priority", ""},
+		{"", ""},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	clauseTokens := regexp.MustCompile(`"[a-z_]+"|\bDESC\b|,|\s+`)
+	f.Fuzz(func(t *testing.T, spec, order string) {
+		sort, err := ParseSort(spec, order, TaskSchema)
+		if err != nil {
+			return
+		}
+		for _, s := range sort {
+			field, ok := TaskSchema[s.Field]
+			if !ok || field.Column != s.Column {
+				t.Fatalf("ParseSort(%q) produced field %q with column %q", spec, s.Field, s.Column)
+			}
+		}
+
+		stmt := dryRun.Session(&gorm.Session{}).Model(&models.Task{}).Order(sort.Clause()).Find(&[]models.Task{}).Statement
+		sql := stmt.SQL.String()
+		_, orderBy, ok := strings.Cut(sql, " ORDER BY ")
+		if !ok {
+			t.Fatalf("no ORDER BY clause in %q", sql)
+		}
+		if rest := clauseTokens.ReplaceAllString(orderBy, ""); rest != "" {
+			t.Fatalf("unexpected text %q in ORDER BY clause %q", rest, orderBy)
+		}
+		if len(stmt.Vars) != 0 {
+			t.Fatalf("ORDER BY clause %q binds parameters %v", orderBy, stmt.Vars)
+		}
+	})
+}
+
+// FuzzCompile compiles expressions built in code, as from Kafka messages or
+// request bodies, which skip the parser and its checks.
+func FuzzCompile(f *testing.F) {
+	f.Add("title", uint8(0), "deploy", "x", false)
+	f.Add("priority", uint8(1), "2", "3", true)
+	f.Add("description", uint8(6), `50%_off\`, "", false)
+	f.Add("status", uint8(8), "a'); DROP TABLE tasks; --", `"`, true)
+	f.Add(`title" = '' OR 1=1 --`, uint8(0), "x", "y", false)
+	f.Add("created_at", uint8(4), "2024-01-01", "2024-02-01T00:00:00Z", false)
+
+	ops := []Operator{OpEq, OpNeq, OpLt, OpLte, OpGt, OpGte, OpContains, OpExcludes, OpIn, OpNotIn, "; DROP"}
+	f.Fuzz(func(t *testing.T, field string, op uint8, first, second string, negate bool) {
+		cmp := &Comparison{Field: field, Op: ops[int(op)%len(ops)], Values: []Value{{Raw: first}}}
+		if cmp.Op == OpIn || cmp.Op == OpNotIn {
+			cmp.Values = append(cmp.Values, Value{Raw: second})
+		}
+		var expr Expr = cmp
+		if negate {
+			expr = &Not{X: expr}
+		}
+		expr = And(expr, Equal("status", second))
+
+		condition, err := Compile(expr, TaskSchema)
+		if _, known := TaskSchema[field]; (!known || cmp.Op == "; DROP") && err == nil {
+			t.Fatalf("Compile(%s) accepted an unknown field or operator", expr)
+		}
+		if err != nil {
+			return
+		}
+		where, vars := render(t, condition)
+		checkWhere(t, expr, where, vars)
+	})
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of input"
+	case tokenWord:
+		return "word"
+	case tokenString:
+		return "string"
+	case tokenOperator:
+		return "operator"
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenComma:
+		return `","`
+	}
+	return "token"
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the 1-based rune offset of the token in the input.
+	pos int
+}
+
+// keyword reports whether the token is the given case-insensitive keyword.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, kw)
+}
+
+type lexer struct {
+	input []rune
+	pos   int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, pos: l.pos + 1}, nil
+	}
+
+	start := l.pos
+	r := l.input[l.pos]
+	switch {
+	case r == '(':
+		l.pos++
+		return token{kind: tokenLParen, text: "(", pos: start + 1}, nil
+	case r == ')':
+		l.pos++
+		return token{kind: tokenRParen, text: ")", pos: start + 1}, nil
+	case r == ',':
+		l.pos++
+		return token{kind: tokenComma, text: ",", pos: start + 1}, nil
+	case r == '"' || r == '\'':
+		return l.quoted(r)
+	case strings.ContainsRune("=!<>~", r):
+		return l.operator()
+	case isWordRune(r):
+		for l.pos < len(l.input) && isWordRune(l.input[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenWord, text: string(l.input[start:l.pos]), pos: start + 1}, nil
+	}
+	return token{}, errorf(start+1, "unexpected character %q", r)
+}
+
+func (l *lexer) quoted(quote rune) (token, error) {
+	start := l.pos
+	l.pos++
+
+	var text strings.Builder
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case r == '\\' && l.pos+1 < len(l.input):
+			text.WriteRune(l.input[l.pos+1])
+			l.pos += 2
+		case r == quote:
+			l.pos++
+			return token{kind: tokenString, text: text.String(), pos: start + 1}, nil
+		default:
+			text.WriteRune(r)
+			l.pos++
+		}
+	}
+	return token{}, errorf(start+1, "unterminated string")
+}
+
+func (l *lexer) operator() (token, error) {
+	start := l.pos
+	for _, op := range []string{"!=", "<=", ">=", "!~", "=", "<", ">", "~"} {
+		end := start + len(op)
+		if end <= len(l.input) && string(l.input[start:end]) == op {
+			l.pos = end
+			return token{kind: tokenOperator, text: op, pos: start + 1}, nil
+		}
+	}
+	return token{}, errorf(start+1, "unexpected character %q", l.input[start])
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:+", r)
+}
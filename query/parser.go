@@ -0,0 +1,254 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxFilterLength = 4096
+	maxFilterDepth  = 32
+)
+
+// Error is a filter error located at a 1-based position of the input. Pos is
+// 0 for errors in expressions built in code.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Pos == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+func errorf(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Parse parses a filter expression such as
+//
+//	priority>=2 and status in (todo,blocked) and title ~ "deploy"
+//
+// The grammar is
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~"
+//
+// Values are bare words or single/double quoted strings. Parse only checks the
+// syntax; field names and values are checked by Compile.
+func Parse(input string) (Expr, error) {
+	if len([]rune(input)) > maxFilterLength {
+		return nil, errorf(maxFilterLength, "filter longer than %d characters", maxFilterLength)
+	}
+
+	p := &parser{lexer: lexer{input: []rune(input)}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenEOF {
+		return nil, errorf(p.tok.pos, "empty filter")
+	}
+
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.unexpected("\"and\", \"or\" or end of input")
+	}
+	return expr, nil
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected(want string) *Error {
+	if p.tok.kind == tokenEOF {
+		return errorf(p.tok.pos, "expected %s, got end of input", want)
+	}
+	return errorf(p.tok.pos, "expected %s, got %q", want, p.tok.text)
+}
+
+func (p *parser) parseOr(depth int) (Expr, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.keyword("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "or", Left: left, Right: right, pos: left.Pos()}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Expr, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.keyword("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "and", Left: left, Right: right, pos: left.Pos()}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary(depth int) (Expr, error) {
+	if depth > maxFilterDepth {
+		return nil, errorf(p.tok.pos, "filter nested deeper than %d levels", maxFilterDepth)
+	}
+
+	switch {
+	case p.tok.keyword("not"):
+		pos := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x, pos: pos}, nil
+
+	case p.tok.kind == tokenLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, p.unexpected(`")"`)
+		}
+		return x, p.advance()
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	if p.tok.kind != tokenWord || p.tok.keyword("and") || p.tok.keyword("or") || p.tok.keyword("in") {
+		return nil, p.unexpected("field name")
+	}
+	cmp := &Comparison{Field: p.tok.text, pos: p.tok.pos}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	cmp.opPos = p.tok.pos
+	switch {
+	case p.tok.kind == tokenOperator:
+		cmp.Op = Operator(p.tok.text)
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		cmp.Values = []Value{value}
+		return cmp, nil
+
+	case p.tok.keyword("in"):
+		cmp.Op = OpIn
+
+	case p.tok.keyword("not"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.tok.keyword("in") {
+			return nil, p.unexpected(`"in"`)
+		}
+		cmp.Op = OpNotIn
+
+	default:
+		return nil, p.unexpected("comparison operator")
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	values, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	cmp.Values = values
+	return cmp, nil
+}
+
+func (p *parser) parseList() ([]Value, error) {
+	if p.tok.kind != tokenLParen {
+		return nil, p.unexpected(`"("`)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var values []Value
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		switch p.tok.kind {
+		case tokenComma:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokenRParen:
+			return values, p.advance()
+		default:
+			return nil, p.unexpected(`"," or ")"`)
+		}
+	}
+}
+
+func (p *parser) parseValue() (Value, error) {
+	if p.tok.kind != tokenWord && p.tok.kind != tokenString {
+		return Value{}, p.unexpected("value")
+	}
+	if p.tok.kind == tokenWord && isKeyword(p.tok.text) {
+		return Value{}, errorf(p.tok.pos, "expected value, got keyword %q (quote it to use it as a value)", p.tok.text)
+	}
+	value := Value{Raw: p.tok.text, Pos: p.tok.pos}
+	return value, p.advance()
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not", "in":
+		return true
+	}
+	return false
+}
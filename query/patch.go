@@ -0,0 +1,64 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Patch assigns new values to schema fields, keyed by database column.
+type Patch map[string]interface{}
+
+// ParsePatch parses a JSON object of field values such as
+// {"priority": 1, "status": "Blocked"}. Every field must be in the schema
+// and not listed in readOnly, and values must match the field type; times
+// are RFC 3339 strings.
+func ParsePatch(data []byte, s Schema, readOnly ...string) (Patch, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("patch must be an object: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("patch is empty")
+	}
+
+	patch := make(Patch, len(values))
+	for name, raw := range values {
+		field, ok := s[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if slices.Contains(readOnly, name) {
+			return nil, fmt.Errorf("field %q is read-only", name)
+		}
+		value, err := patchValue(field, raw)
+		if err != nil {
+			return nil, err
+		}
+		patch[field.Column] = value
+	}
+	return patch, nil
+}
+
+func patchValue(field Field, raw json.RawMessage) (interface{}, error) {
+	switch field.Kind {
+	case KindInt:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("invalid integer %s for field %q", raw, field.Name)
+		}
+		return n, nil
+	case KindTime:
+		var t time.Time
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, fmt.Errorf("invalid time %s for field %q, expected RFC 3339", raw, field.Name)
+		}
+		return t, nil
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return nil, fmt.Errorf("invalid string %s for field %q", raw, field.Name)
+	}
+	return str, nil
+}
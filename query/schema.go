@@ -0,0 +1,88 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drive-deep/task-microservice/models"
+	"gorm.io/gorm/schema"
+)
+
+// Kind is the value type of a schema field.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindTime
+)
+
+// Field is a model field exposed to filters under its JSON name.
+type Field struct {
+	Name   string
+	Column string
+	Kind   Kind
+}
+
+// Schema is the allow-list of fields a filter may reference.
+type Schema map[string]Field
+
+// TaskSchema exposes the fields of models.Task.
+var TaskSchema = MustSchema(&models.Task{},
+	"id", "title", "description", "status", "priority", "created_at", "updated_at")
+
+// NewSchema builds a schema from the listed JSON field names of model, taking
+// column names and types from its GORM mapping.
+func NewSchema(model interface{}, fields ...string) (Schema, error) {
+	parsed, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*schema.Field)
+	for _, f := range parsed.Fields {
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" && f.DBName != "" {
+			byName[name] = f
+		}
+	}
+
+	s := make(Schema, len(fields))
+	for _, name := range fields {
+		f, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no field %q", parsed.Name, name)
+		}
+		kind, err := kindOf(f.FieldType)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", name, err)
+		}
+		s[name] = Field{Name: name, Column: f.DBName, Kind: kind}
+	}
+	return s, nil
+}
+
+// MustSchema is like NewSchema but panics on error.
+func MustSchema(model interface{}, fields ...string) Schema {
+	s, err := NewSchema(model, fields...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func kindOf(t reflect.Type) (Kind, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return KindString, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return KindInt, nil
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return KindTime, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", t)
+}
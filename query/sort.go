@@ -0,0 +1,90 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// SortField orders results by one schema field.
+type SortField struct {
+	Field  string
+	Column string
+	Desc   bool
+}
+
+// Sort is an ordered list of sort fields, most significant first.
+type Sort []SortField
+
+// ParseSort parses a comma separated sort specification such as
+// "priority desc,created_at" or "-priority,created_at". Fields without an
+// explicit direction use defaultOrder, which must be "asc", "desc" or empty
+// for ascending. Every field must be in the schema.
+func ParseSort(spec, defaultOrder string, s Schema) (Sort, error) {
+	defaultDesc, err := parseDirection(defaultOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	var sort Sort
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("invalid sort field %q", strings.TrimSpace(part))
+		}
+
+		name, desc := words[0], defaultDesc
+		if strings.HasPrefix(name, "-") {
+			name, desc = name[1:], true
+		}
+		if len(words) == 2 {
+			if desc, err = parseDirection(words[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		field, ok := s[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate sort field %q", name)
+		}
+		seen[name] = true
+		sort = append(sort, SortField{Field: name, Column: field.Column, Desc: desc})
+	}
+	return sort, nil
+}
+
+func parseDirection(order string) (bool, error) {
+	switch strings.ToLower(order) {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid sort order %q", order)
+}
+
+// Clause returns the ORDER BY clause for the sort. Columns are quoted by the
+// dialect, so they never reach the SQL text verbatim.
+func (s Sort) Clause() clause.OrderBy {
+	columns := make([]clause.OrderByColumn, len(s))
+	for i, f := range s {
+		columns[i] = clause.OrderByColumn{Column: clause.Column{Name: f.Column}, Desc: f.Desc}
+	}
+	return clause.OrderBy{Columns: columns}
+}
+
+func (s Sort) String() string {
+	parts := make([]string, len(s))
+	for i, f := range s {
+		parts[i] = f.Field + " asc"
+		if f.Desc {
+			parts[i] = f.Field + " desc"
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,91 @@
+#!/bin/bash
+# Starts the Redis Sentinel and Cluster deployments of docker-compose.redis-ha.yaml,
+# runs the cache integration tests against both and checks that a cached key
+# survives the loss of its master in both.
+set -e
+
+COMPOSE="docker compose -f docker-compose.redis-ha.yaml"
+KEY="{task-service:v2:0}:task:failover-check"
+
+wait_for() {
+    local description=$1
+    shift
+    for _ in $(seq 1 60); do
+        if "$@" >/dev/null 2>&1; then
+            return 0
+        fi
+        sleep 1
+    done
+    echo "Timed out waiting for $description"
+    exit 1
+}
+
+sentinel_master() {
+    $COMPOSE exec -T redis-sentinel1 redis-cli -p 26379 sentinel get-master-addr-by-name mymaster | head -1
+}
+
+replica_synced() {
+    $COMPOSE exec -T redis-replica redis-cli info replication | grep -q "master_link_status:up"
+}
+
+master_changed() {
+    local master
+    master=$(sentinel_master)
+    [ -n "$master" ] && [ "$master" != "$OLD_MASTER" ]
+}
+
+cluster_ok() {
+    $COMPOSE exec -T redis-node1 redis-cli cluster info | grep -q "cluster_state:ok"
+}
+
+key_readable() {
+    [ "$($COMPOSE exec -T "$1" redis-cli -c get "$KEY" | tr -d '\r')" = "cached" ]
+}
+
+# slot_owner prints the container of the master serving KEY.
+slot_owner() {
+    local port ip
+    read -r ip port < <($COMPOSE exec -T redis-node1 redis-cli cluster shards |
+        awk -v slot="$($COMPOSE exec -T redis-node1 redis-cli cluster keyslot "$KEY" | tr -d '\r')" '
+            /^slots$/ { getline a; getline b; inrange = (slot >= a && slot <= b) }
+            inrange && /^ip$/ { getline ip }
+            inrange && /^role$/ { getline role; if (role == "master") { print ip; exit } }')
+    for n in 1 2 3 4 5 6; do
+        if [ "$(docker inspect -f '{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}' redis-node$n)" = "$ip" ]; then
+            echo redis-node$n
+        fi
+    done
+}
+
+$COMPOSE up -d
+trap '$COMPOSE down -v' EXIT
+
+echo "== Integration tests"
+wait_for "sentinels to monitor the master" sentinel_master
+wait_for "the cluster to form" cluster_ok
+$COMPOSE --profile test run --rm cache-integration-test
+
+echo "== Sentinel"
+wait_for "sentinels to monitor the master" sentinel_master
+wait_for "the replica to sync" replica_synced
+$COMPOSE exec -T redis-master redis-cli set "$KEY" cached >/dev/null
+wait_for "the key to replicate" key_readable redis-replica
+OLD_MASTER=$(sentinel_master)
+echo "Master is $OLD_MASTER; stopping it"
+$COMPOSE stop redis-master
+wait_for "sentinels to promote the replica" master_changed
+echo "Master is now $(sentinel_master)"
+key_readable redis-replica || { echo "Key lost after failover"; exit 1; }
+echo "Sentinel failover OK"
+
+echo "== Cluster"
+wait_for "the cluster to form" cluster_ok
+$COMPOSE exec -T redis-node1 redis-cli -c set "$KEY" cached >/dev/null
+owner=$(slot_owner)
+[ -n "$owner" ] || { echo "Could not find the master of $KEY"; exit 1; }
+survivor=redis-node1
+[ "$owner" = redis-node1 ] && survivor=redis-node2
+echo "$KEY is served by $owner; stopping it"
+docker stop "$owner" >/dev/null
+wait_for "a replica to take over the slot" key_readable "$survivor"
+echo "Cluster failover OK"
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/drive-deep/task-microservice/query"
+
+	"gorm.io/gorm"
+)
+
+// archiveTable holds archived tasks, colocated with tasks on Citus.
+const archiveTable = "tasks_archive"
+
+// taskColumns are the columns tasks and archived tasks share.
+const taskColumns = "id, title, description, status, priority, created_at, updated_at"
+
+// ErrArchived is returned for writes to archived tasks, which are read-only.
+var ErrArchived = errors.New("task is archived")
+
+// withArchiveQuery reads tasks and archived tasks as one table.
+const withArchiveQuery = "SELECT " + taskColumns + ", NULL AS archived_at FROM tasks " +
+	"UNION ALL SELECT " + taskColumns + ", archived_at FROM " + archiveTable
+
+// TaskArchiver is implemented by repositories that move old tasks to an
+// archive table, out of the way of listings. Archived tasks are still found by
+// GetByID, with ArchivedAt set, and listed with ListOptions.IncludeArchived.
+type TaskArchiver interface {
+	// Archive moves up to limit tasks in one of statuses last updated before
+	// cutoff to the archive, oldest first, and returns their IDs.
+	Archive(ctx context.Context, statuses []string, cutoff time.Time, limit int) ([]string, error)
+	// CountWithArchived returns the number of tasks matching filter,
+	// archived or not.
+	CountWithArchived(ctx context.Context, filter query.Expr) (int64, error)
+}
+
+func (r *taskStore) Archive(ctx context.Context, statuses []string, cutoff time.Time, limit int) ([]string, error) {
+	var ids []string
+	// Moving tasks again is harmless, so the move is retried like a read.
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+			ids = nil
+			err := tx.Model(&Task{}).Where("status IN ? AND updated_at < ?", statuses, cutoff).
+				Order("updated_at").Limit(limit).Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			err = tx.Exec("INSERT INTO "+archiveTable+" ("+taskColumns+", archived_at) "+
+				"SELECT "+taskColumns+", ? FROM tasks WHERE id IN ? "+
+				"ON CONFLICT (id) DO UPDATE SET title = excluded.title, description = excluded.description, "+
+				"status = excluded.status, priority = excluded.priority, created_at = excluded.created_at, "+
+				"updated_at = excluded.updated_at, archived_at = excluded.archived_at",
+				time.Now(), ids).Error
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&Task{}, "id IN ?", ids).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *taskStore) CountWithArchived(ctx context.Context, filter query.Expr) (int64, error) {
+	return r.count(ctx, filter, true)
+}
+
+// createTasks inserts tasks, unless one of them has the ID of an archived
+// task: archived IDs stay taken, and ErrArchived is returned. Checking after
+// the insert, in the same transaction, also catches a task archived
+// concurrently, whose row the insert waited for.
+func createTasks(tx *gorm.DB, tasks []*Task) error {
+	if err := tx.CreateInBatches(tasks, batchInsertSize).Error; err != nil {
+		return err
+	}
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	var archived int64
+	if err := tx.Table(archiveTable).Where("id IN ?", ids).Count(&archived).Error; err != nil {
+		return err
+	}
+	if archived > 0 {
+		return ErrArchived
+	}
+	return nil
+}
+
+// updateTask overwrites the stored task with the ID of task. It returns
+// ErrArchived for archived tasks and ErrNotFound for missing ones, rather
+// than inserting a row like Save would.
+func updateTask(tx *gorm.DB, task *Task) error {
+	result := tx.Model(&Task{}).Where("id = ?", task.ID).Select("*").Updates(task)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	var archived int64
+	if err := tx.Table(archiveTable).Where("id = ?", task.ID).Count(&archived).Error; err != nil {
+		return err
+	}
+	if archived > 0 {
+		return ErrArchived
+	}
+	return ErrNotFound
+}
+
+// deleteTasks deletes the tasks with ids from both tables.
+func deleteTasks(tx *gorm.DB, ids []string) error {
+	if err := tx.Delete(&Task{}, "id IN ?", ids).Error; err != nil {
+		return err
+	}
+	return tx.Table(archiveTable).Where("id IN ?", ids).Delete(&Task{}).Error
+}
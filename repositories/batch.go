@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of batch operations.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// batchInsertSize is the number of rows inserted per statement.
+const batchInsertSize = 100
+
+// ErrBatchRolledBack is returned by WriteBatch, along with the errors of the
+// failed operations, when an atomic batch was rolled back.
+var ErrBatchRolledBack = errors.New("batch rolled back")
+
+// BatchOp is a write of a batch: Entity is created or updated, or the entity
+// with ID is deleted.
+type BatchOp[T any] struct {
+	Kind   string
+	Entity *T
+	ID     string
+}
+
+// BatchWriter is implemented by repositories that can apply many writes in
+// one transaction.
+type BatchWriter[T any] interface {
+	// WriteBatch applies ops in order and returns the error of each, nil
+	// for those that succeeded; failed operations leave no trace. With
+	// atomic set, any failure rolls back the whole batch and
+	// ErrBatchRolledBack is returned. Any other error means nothing was
+	// applied.
+	WriteBatch(ctx context.Context, ops []BatchOp[T], atomic bool) ([]error, error)
+}
+
+// WriteBatch runs consecutive operations of the same kind as one statement
+// where the database allows, e.g. a multi-row insert.
+func (r *taskStore) WriteBatch(ctx context.Context, ops []BatchOp[Task], atomic bool) ([]error, error) {
+	var errs []error
+	err := r.run(ctx, false, func(ctx context.Context) error {
+		return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+			// A retried transaction starts over.
+			errs = make([]error, len(ops))
+			for start := 0; start < len(ops); {
+				end := start + 1
+				for end < len(ops) && ops[end].Kind == ops[start].Kind {
+					end++
+				}
+				if err := applyRun(tx, ops[start:end], errs[start:end]); err != nil {
+					return err
+				}
+				start = end
+			}
+			if atomic {
+				for _, err := range errs {
+					if err != nil {
+						return ErrBatchRolledBack
+					}
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil && !errors.Is(err, ErrBatchRolledBack) {
+		return nil, err
+	}
+	return errs, err
+}
+
+// applyRun applies operations of one kind together. When that fails they are
+// retried one by one to find the failing ones. Savepoints keep a failure from
+// aborting the transaction.
+func applyRun(tx *gorm.DB, ops []BatchOp[Task], errs []error) error {
+	if err := tx.SavePoint("batch").Error; err != nil {
+		return err
+	}
+	err := applyOps(tx, ops)
+	if err != nil {
+		if err := tx.RollbackTo("batch").Error; err != nil {
+			return err
+		}
+		if len(ops) == 1 {
+			errs[0] = err
+		} else {
+			for i := range ops {
+				if err := applyRun(tx, ops[i:i+1], errs[i:i+1]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// Savepoints of the same name nest; this releases the one set above.
+	return tx.Exec("RELEASE SAVEPOINT batch").Error
+}
+
+func applyOps(tx *gorm.DB, ops []BatchOp[Task]) error {
+	switch ops[0].Kind {
+	case BatchCreate:
+		tasks := make([]*Task, len(ops))
+		for i, op := range ops {
+			tasks[i] = op.Entity
+		}
+		return createTasks(tx, tasks)
+	case BatchUpdate:
+		for _, op := range ops {
+			if err := updateTask(tx, op.Entity); err != nil {
+				return err
+			}
+		}
+		return nil
+	case BatchDelete:
+		ids := make([]string, len(ops))
+		for i, op := range ops {
+			ids[i] = op.ID
+		}
+		return deleteTasks(tx, ids)
+	}
+	return fmt.Errorf("unknown batch operation %q", ops[0].Kind)
+}
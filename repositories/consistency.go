@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type writtenKey struct{}
+
+// session tracks the writes of one request.
+type session struct {
+	// recent is set when the client wrote in an earlier request not long
+	// ago, so replicas may not have its write yet.
+	recent  bool
+	written atomic.Bool
+}
+
+// WithReadYourWrites returns a context in which reads that follow a write
+// are served by the primary database rather than a replica, so they see the
+// write despite replication lag. Each request derives its own. With recent
+// set, e.g. because the client wrote in one of its previous requests, every
+// read of the request goes to the primary.
+func WithReadYourWrites(ctx context.Context, recent bool) context.Context {
+	return context.WithValue(ctx, writtenKey{}, &session{recent: recent})
+}
+
+// Wrote reports whether a write was made in the context itself, as opposed
+// to an earlier request of the client.
+func Wrote(ctx context.Context) bool {
+	s, ok := ctx.Value(writtenKey{}).(*session)
+	return ok && s.written.Load()
+}
+
+// markWritten records a write in the context, if it tracks writes.
+func markWritten(ctx context.Context) {
+	if s, ok := ctx.Value(writtenKey{}).(*session); ok {
+		s.written.Store(true)
+	}
+}
+
+// hasWritten reports whether reads in the context must see writes made
+// before, in the context or a recent earlier request.
+func hasWritten(ctx context.Context) bool {
+	s, ok := ctx.Value(writtenKey{}).(*session)
+	return ok && (s.recent || s.written.Load())
+}
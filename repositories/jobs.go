@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobRecord is the stored state of a background job. Result holds the JSON
+// encoded result of a finished job and Error the failure of a failed one.
+// UpdatedAt is refreshed while the job runs, so jobs whose instance went away
+// can be told from slow ones.
+type JobRecord struct {
+	ID         string `gorm:"primaryKey"`
+	Kind       string
+	State      string
+	Total      int64
+	Done       int64
+	Result     string
+	Error      string
+	StartedAt  time.Time
+	UpdatedAt  time.Time `gorm:"autoUpdateTime:false"`
+	FinishedAt *time.Time
+}
+
+func (JobRecord) TableName() string {
+	return "jobs"
+}
+
+// JobStore is implemented by repositories that keep the state of background
+// jobs, so every instance can report a job and jobs outlive the instance
+// running them.
+type JobStore interface {
+	CreateJob(ctx context.Context, job *JobRecord) error
+	// UpdateJob stores the progress or outcome of a job in the running
+	// state. It returns ErrNotFound when the job no longer runs, e.g.
+	// because FailStaleJobs gave up on it.
+	UpdateJob(ctx context.Context, job *JobRecord) error
+	GetJob(ctx context.Context, id string) (*JobRecord, error)
+	// FailStaleJobs marks running jobs last updated before cutoff as failed
+	// with reason and returns how many there were.
+	FailStaleJobs(ctx context.Context, cutoff time.Time, reason string) (int64, error)
+	// DeleteJobs deletes jobs that finished before cutoff.
+	DeleteJobs(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// States of jobs that UpdateJob and FailStaleJobs deal with, matching those
+// of services.Job.
+const (
+	jobRunning = "running"
+	jobFailed  = "failed"
+)
+
+// Jobs are read from and written to the primary: a replica may lag behind
+// the instance running the job.
+
+func (r *taskStore) CreateJob(ctx context.Context, job *JobRecord) error {
+	return r.run(ctx, false, func(ctx context.Context) error {
+		return r.db.WithContext(ctx).Create(job).Error
+	})
+}
+
+func (r *taskStore) UpdateJob(ctx context.Context, job *JobRecord) error {
+	var affected int64
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		result := r.db.WithContext(ctx).Model(&JobRecord{}).
+			Where("id = ? AND state = ?", job.ID, jobRunning).
+			Select("state", "done", "result", "error", "updated_at", "finished_at").
+			Updates(job)
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *taskStore) GetJob(ctx context.Context, id string) (*JobRecord, error) {
+	var job JobRecord
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		return r.db.WithContext(ctx).First(&job, "id = ?", id).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *taskStore) FailStaleJobs(ctx context.Context, cutoff time.Time, reason string) (int64, error) {
+	var failed int64
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		result := r.db.WithContext(ctx).Model(&JobRecord{}).
+			Where("state = ? AND updated_at < ?", jobRunning, cutoff).
+			Updates(map[string]interface{}{"state": jobFailed, "error": reason, "updated_at": now, "finished_at": now})
+		failed = result.RowsAffected
+		return result.Error
+	})
+	return failed, err
+}
+
+func (r *taskStore) DeleteJobs(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		result := r.db.WithContext(ctx).Where("finished_at < ?", cutoff).Delete(&JobRecord{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
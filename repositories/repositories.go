@@ -1,9 +1,44 @@
 package repositories
 
+import (
+	"context"
+	"errors"
+
+	"github.com/drive-deep/task-microservice/query"
+)
+
+// ErrNotFound is returned by GetByID when no entity has the ID.
+var ErrNotFound = errors.New("record not found")
+
+// ListOptions selects, orders and paginates the entities returned by GetAll.
+type ListOptions struct {
+	Filter query.Expr
+	Sort   query.Sort
+	// Fields limits the columns loaded; empty loads every column.
+	Fields   query.Fields
+	Page     int
+	PageSize int
+	// IncludeArchived lists archived entities along with the others, see
+	// TaskArchiver.
+	IncludeArchived bool
+}
+
+// Repository is the storage port of the service. Adapters exist for
+// PostgreSQL, optionally distributed with Citus, and for SQLite; every method
+// gives up when ctx is done.
 type Repository[T any] interface {
-	Create(entity *T) error
-	GetByID(id string) (*T, error)
-	GetAll(filter map[string]interface{}, sort string, page, pageSize int) ([]T, error)
-	Update(entity *T) error
-	Delete(id string) error
+	Create(ctx context.Context, entity *T) error
+	GetByID(ctx context.Context, id string) (*T, error)
+	GetAll(ctx context.Context, opts ListOptions) ([]T, error)
+	Count(ctx context.Context, filter query.Expr, estimate bool) (int64, error)
+	Update(ctx context.Context, entity *T) error
+	Delete(ctx context.Context, id string) error
+}
+
+// BatchReader is implemented by repositories that can stream every entity,
+// e.g. to rebuild a cache.
+type BatchReader[T any] interface {
+	// GetBatch returns up to size entities with IDs greater than afterID, in
+	// ID order. Passing the last ID of one batch returns the next one.
+	GetBatch(ctx context.Context, afterID string, size int) ([]T, error)
 }
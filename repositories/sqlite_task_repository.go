@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/drive-deep/task-microservice/config"
+	"github.com/drive-deep/task-microservice/query"
+
+	"gorm.io/gorm"
+)
+
+// SQLiteTaskRepository stores tasks in a SQLite database, e.g. a single file
+// for running the service locally. It has no full-text search and counts are
+// always exact.
+type SQLiteTaskRepository struct {
+	*taskStore
+}
+
+func NewSQLiteTaskRepository(db *gorm.DB, cfg config.DatabaseConfig) *SQLiteTaskRepository {
+	return &SQLiteTaskRepository{taskStore: newTaskStore("sqlite", db, cfg)}
+}
+
+// Count returns the number of tasks matching filter. SQLite keeps no row
+// estimates, so estimate is ignored.
+func (r *SQLiteTaskRepository) Count(ctx context.Context, filter query.Expr, estimate bool) (int64, error) {
+	return r.count(ctx, filter, false)
+}
@@ -1,56 +1,237 @@
 package repositories
 
 import (
-    "fmt"
+    "context"
+    "errors"
+    "sync/atomic"
+    "time"
+
+    "github.com/drive-deep/task-microservice/config"
     "github.com/drive-deep/task-microservice/models"
+    "github.com/drive-deep/task-microservice/query"
+    "github.com/drive-deep/task-microservice/resilience"
 
     "gorm.io/gorm"
 )
 
 type Task = models.Task
 
-type TaskRepository struct {
+// taskStore holds the task queries that GORM can express for every supported
+// database. The adapters embed it and add what is specific to their database.
+type taskStore struct {
     db *gorm.DB
+    // replicas serve reads in turn, if any; next picks the replica.
+    replicas []*gorm.DB
+    next     atomic.Uint32
+    // timeout bounds every query, on top of the caller's context.
+    timeout time.Duration
+    // retry runs queries again after transient failures, and breaker stops
+    // sending them while the database is unavailable.
+    retry   *resilience.Retry
+    breaker *resilience.Breaker
+}
+
+// newTaskStore returns a store for db, whose retries and circuit breaker are
+// reported as the dependency name.
+func newTaskStore(name string, db *gorm.DB, cfg config.DatabaseConfig, replicas ...*gorm.DB) *taskStore {
+    return &taskStore{
+        db:       db,
+        replicas: replicas,
+        timeout:  cfg.Timeout,
+        retry:    resilience.NewRetry(name, cfg.Retry.MaxAttempts, cfg.Retry.BaseDelay, cfg.Retry.MaxDelay),
+        breaker:  resilience.NewBreaker(name, cfg.Breaker.FailureThreshold, cfg.Breaker.OpenTimeout, unavailable),
+    }
 }
 
-func NewTaskRepository(db *gorm.DB) *TaskRepository {
-    return &TaskRepository{db}
+// reader returns the database to read from: the next replica, or the primary
+// once the context has written, see WithReadYourWrites.
+func (r *taskStore) reader(ctx context.Context) *gorm.DB {
+    if len(r.replicas) == 0 || hasWritten(ctx) {
+        return r.db.WithContext(ctx)
+    }
+    return r.replicas[r.next.Add(1)%uint32(len(r.replicas))].WithContext(ctx)
+}
+
+// writer returns the primary database and makes later reads in the context
+// go there too.
+func (r *taskStore) writer(ctx context.Context) *gorm.DB {
+    markWritten(ctx)
+    return r.db.WithContext(ctx)
 }
 
-func (r *TaskRepository) Create(entity *Task) error {
-    return r.db.Create(entity).Error
+func (r *taskStore) Create(ctx context.Context, entity *Task) error {
+    return r.run(ctx, false, func(ctx context.Context) error {
+        return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+            return createTasks(tx, []*Task{entity})
+        })
+    })
 }
 
-func (r *TaskRepository) GetByID(id string) (*Task, error) {
+func (r *taskStore) GetByID(ctx context.Context, id string) (*Task, error) {
     var task Task
-    err := r.db.First(&task, "id = ?", id).Error
+    err := r.run(ctx, true, func(ctx context.Context) error {
+        err := r.reader(ctx).First(&task, "id = ?", id).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            // Archived tasks are still found by ID.
+            err = r.reader(ctx).Table(archiveTable).First(&task, "id = ?", id).Error
+        }
+        return err
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrNotFound
+    }
     return &task, err
 }
 
-func (r *TaskRepository) GetAll(filter map[string]interface{}, sort string, page, pageSize int) ([]Task, error) {
+func (r *taskStore) GetAll(ctx context.Context, opts ListOptions) ([]Task, error) {
     var tasks []Task
-    query := r.db.Model(&Task{})
+    err := r.run(ctx, true, func(ctx context.Context) error {
+        // Apply filters
+        db, err := r.filtered(ctx, opts.Filter, opts.IncludeArchived)
+        if err != nil {
+            return err
+        }
 
-    // Apply filters
-    for key, value := range filter {
-        query = query.Where(fmt.Sprintf("%s = ?", key), value)
-    }
+        // Load only the requested columns
+        if len(opts.Fields) > 0 {
+            db = db.Select(opts.Fields.Columns())
+        }
 
-    // Apply sorting
-    if sort != "" {
-        query = query.Order(sort)
+        // Apply sorting, with id as tie-breaker so pages are stable
+        if len(opts.Sort) > 0 {
+            db = db.Order(opts.Sort.Clause())
+        }
+        db = db.Order("id")
+
+        // Apply pagination
+        offset := (opts.Page - 1) * opts.PageSize
+        return db.Limit(opts.PageSize).Offset(offset).Find(&tasks).Error
+    })
+    if err != nil {
+        return nil, err
     }
+    return tasks, nil
+}
 
-    // Apply pagination
-    offset := (page - 1) * pageSize
-    err := query.Limit(pageSize).Offset(offset).Find(&tasks).Error
+// count returns the exact number of tasks matching filter, optionally
+// including archived ones.
+func (r *taskStore) count(ctx context.Context, filter query.Expr, includeArchived bool) (int64, error) {
+    var count int64
+    err := r.run(ctx, true, func(ctx context.Context) error {
+        db, err := r.filtered(ctx, filter, includeArchived)
+        if err != nil {
+            return err
+        }
+        return db.Count(&count).Error
+    })
+    return count, err
+}
+
+// GetBatch pages by ID rather than offset, so every batch is an index range
+// scan however far the iteration got. It reads from the primary, since
+// batches fill the cache and a lagging replica could bring back deleted
+// tasks.
+func (r *taskStore) GetBatch(ctx context.Context, afterID string, size int) ([]Task, error) {
+    var tasks []Task
+    err := r.run(ctx, true, func(ctx context.Context) error {
+        return r.db.WithContext(ctx).Where("id > ?", afterID).Order("id").Limit(size).Find(&tasks).Error
+    })
     return tasks, err
 }
 
-func (r *TaskRepository) Update(entity *Task) error {
-    return r.db.Save(entity).Error
+func (r *taskStore) Update(ctx context.Context, entity *Task) error {
+    return r.run(ctx, true, func(ctx context.Context) error {
+        return updateTask(r.writer(ctx), entity)
+    })
 }
 
-func (r *TaskRepository) Delete(id string) error {
-    return r.db.Delete(&Task{}, "id = ?", id).Error
-}
\ No newline at end of file
+// Delete deletes the task with id, archived or not.
+func (r *taskStore) Delete(ctx context.Context, id string) error {
+    return r.run(ctx, true, func(ctx context.Context) error {
+        return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+            return deleteTasks(tx, []string{id})
+        })
+    })
+}
+
+// filtered scopes a tasks query to the rows matching filter, read from both
+// the tasks and the archive table with includeArchived set.
+func (r *taskStore) filtered(ctx context.Context, filter query.Expr, includeArchived bool) (*gorm.DB, error) {
+    db := r.reader(ctx).Model(&Task{})
+    if includeArchived {
+        db = r.reader(ctx).Table("(?) AS tasks", gorm.Expr(withArchiveQuery))
+    }
+    if filter == nil {
+        return db, nil
+    }
+    condition, err := query.Compile(filter, query.TaskSchema)
+    if err != nil {
+        return nil, err
+    }
+    return db.Where(condition), nil
+}
+
+// run calls fn with a context bounded by the query timeout, through the
+// circuit breaker. Transient failures are retried with backoff; unless
+// idempotent is set, only those that left nothing applied.
+func (r *taskStore) run(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+    isRetryable := func(err error) bool { return retryable(err, idempotent) }
+    return r.retry.Do(ctx, isRetryable, func() error {
+        return r.breaker.Do(ctx, func() error {
+            ctx, cancel := r.withTimeout(ctx)
+            defer cancel()
+            return fn(ctx)
+        })
+    })
+}
+
+// withTimeout bounds ctx by the configured query timeout, if any.
+func (r *taskStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    if r.timeout <= 0 {
+        return context.WithCancel(ctx)
+    }
+    return context.WithTimeout(ctx, r.timeout)
+}
+
+// TaskRepository stores tasks in PostgreSQL, optionally distributed with
+// Citus. It supports full-text search.
+type TaskRepository struct {
+    *taskStore
+    searchLanguage string
+}
+
+// NewTaskRepository returns a repository writing to db and reading from the
+// replicas, if any.
+func NewTaskRepository(db *gorm.DB, cfg *config.Config, replicas ...*gorm.DB) *TaskRepository {
+    return &TaskRepository{taskStore: newTaskStore("postgres", db, cfg.Database, replicas...), searchLanguage: cfg.Search.Language}
+}
+
+// Count returns the number of tasks matching filter. When estimate is set and
+// no filter is given, the count is taken from the planner statistics instead
+// of scanning the table, which is much cheaper on large distributed tables.
+func (r *TaskRepository) Count(ctx context.Context, filter query.Expr, estimate bool) (int64, error) {
+    if estimate && filter == nil {
+        if count, err := r.estimateCount(ctx); err == nil && count > 0 {
+            return count, nil
+        }
+    }
+    return r.count(ctx, filter, false)
+}
+
+// estimateCount sums reltuples over all shards of the distributed tasks table,
+// falling back to the local pg_class entry when Citus is not available.
+func (r *TaskRepository) estimateCount(ctx context.Context) (int64, error) {
+    var count int64
+    err := r.run(ctx, true, func(ctx context.Context) error {
+        db := r.reader(ctx)
+        err := db.Raw(`SELECT coalesce(sum(result::bigint), 0) FROM run_command_on_shards('tasks',
+            $$SELECT greatest(reltuples, 0)::bigint FROM pg_class WHERE oid = '%s'::regclass$$)
+            WHERE success`).Scan(&count).Error
+        if err == nil {
+            return nil
+        }
+
+        return db.Raw("SELECT greatest(reltuples, 0)::bigint FROM pg_class WHERE oid = 'tasks'::regclass").Scan(&count).Error
+    })
+    return count, err
+}
@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/query"
+	"gorm.io/gorm"
+)
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5"
+
+// SearchQuery describes a full-text search over task titles and descriptions.
+type SearchQuery struct {
+	Text string
+	// Language is the text search configuration used to parse Text. Empty
+	// means the configuration the search_vector column is built with.
+	Language string
+	// Prefix makes every term match words starting with it.
+	Prefix   bool
+	Filter   query.Expr
+	Page     int
+	PageSize int
+}
+
+// TaskSearcher is implemented by repositories that support full-text search.
+type TaskSearcher interface {
+	Search(ctx context.Context, search SearchQuery) ([]models.TaskSearchResult, int64, error)
+}
+
+// Search returns the page of tasks matching search ordered by rank, along with
+// the total number of matches.
+func (r *TaskRepository) Search(ctx context.Context, search SearchQuery) ([]models.TaskSearchResult, int64, error) {
+	language := search.Language
+	if language == "" {
+		language = r.searchLanguage
+	}
+
+	tsquery := gorm.Expr("websearch_to_tsquery(?::regconfig, ?)", language, search.Text)
+	if search.Prefix {
+		terms := prefixQuery(search.Text)
+		if terms == "" {
+			return []models.TaskSearchResult{}, 0, nil
+		}
+		tsquery = gorm.Expr("to_tsquery(?::regconfig, ?)", language, terms)
+	}
+
+	// The indexed column can only be used when it was built with the same
+	// configuration, otherwise the document is vectorised on the fly.
+	vector := gorm.Expr("search_vector")
+	if language != r.searchLanguage {
+		vector = gorm.Expr(
+			"setweight(to_tsvector(?::regconfig, coalesce(title, '')), 'A') || setweight(to_tsvector(?::regconfig, coalesce(description, '')), 'B')",
+			language, language)
+	}
+
+	var total int64
+	results := []models.TaskSearchResult{}
+	err := r.run(ctx, true, func(ctx context.Context) error {
+		db, err := r.filtered(ctx, search.Filter, false)
+		if err != nil {
+			return err
+		}
+		// A new session lets the count and the page query share the
+		// conditions.
+		db = db.Where("? @@ ?", vector, tsquery).Session(&gorm.Session{})
+
+		if err := db.Count(&total).Error; err != nil {
+			return err
+		}
+
+		results = results[:0]
+		offset := (search.Page - 1) * search.PageSize
+		return db.
+			Select("id, title, description, status, priority, created_at, updated_at, "+
+				"ts_rank(?, ?) AS rank, "+
+				"ts_headline(?::regconfig, coalesce(title, ''), ?, ?) AS title_highlight, "+
+				"ts_headline(?::regconfig, coalesce(description, ''), ?, ?) AS description_highlight",
+				vector, tsquery,
+				language, tsquery, headlineOptions+", HighlightAll=true",
+				language, tsquery, headlineOptions).
+			Order("rank DESC, id").
+			Limit(search.PageSize).
+			Offset(offset).
+			Scan(&results).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// prefixQuery turns free text into a tsquery where every term is a prefix
+// match, e.g. "depl prod" becomes "depl:* & prod:*". Only letters and digits
+// survive, so the result is always valid tsquery syntax.
+func prefixQuery(text string) string {
+	terms := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
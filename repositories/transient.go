@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Kinds of transient failures, which may not happen again.
+const (
+	notTransient = iota
+	// conflict means the transaction lost to a concurrent one and was rolled
+	// back.
+	conflict
+	// unreachable means the database couldn't be reached, so the statement
+	// wasn't sent.
+	unreachable
+	// interrupted means the connection was lost, possibly after the
+	// statement was applied.
+	interrupted
+)
+
+// transientKind classifies err, e.g. during a failover of the coordinator.
+func transientKind(err error) int {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		// serialization_failure, deadlock_detected
+		case pgErr.Code == "40001", pgErr.Code == "40P01":
+			return conflict
+		// cannot_connect_now, e.g. while a standby is promoted
+		case pgErr.Code == "57P03":
+			return unreachable
+		// admin_shutdown, crash_shutdown and connection exceptions
+		case pgErr.Code == "57P01", pgErr.Code == "57P02", strings.HasPrefix(pgErr.Code, "08"):
+			return interrupted
+		}
+		return notTransient
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) || errors.Is(err, syscall.ECONNREFUSED) {
+		return unreachable
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return interrupted
+	}
+	// SQLite reports a lock held by another connection; the statement
+	// didn't run.
+	if msg := err.Error(); strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY") {
+		return conflict
+	}
+	return notTransient
+}
+
+// retryable reports whether a query that failed with err can be run again.
+// Unless idempotent is set, that's only when the failure left nothing
+// applied, so a write is never applied twice.
+func retryable(err error, idempotent bool) bool {
+	switch transientKind(err) {
+	case conflict, unreachable:
+		return true
+	case interrupted:
+		return idempotent
+	}
+	return false
+}
+
+// unavailable reports whether err suggests the database is down or
+// overloaded, as opposed to rejecting or losing a race for the query. A
+// deadline exceeded is the query timeout: the breaker ignores failures once
+// the caller's context is done.
+func unavailable(err error) bool {
+	switch transientKind(err) {
+	case unreachable, interrupted:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
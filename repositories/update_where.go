@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/drive-deep/task-microservice/query"
+
+	"gorm.io/gorm"
+)
+
+// BulkUpdater is implemented by repositories that can update every entity
+// matching a filter.
+type BulkUpdater[T any] interface {
+	// UpdateWhere applies patch to up to limit entities matching filter with
+	// IDs greater than afterID, in ID order, and returns the entities it
+	// looked at as they are now along with the number updated. Passing the
+	// last ID returned continues with the next entities, even when the patch
+	// makes updated ones stop matching.
+	UpdateWhere(ctx context.Context, filter query.Expr, patch query.Patch, afterID string, limit int) ([]T, int64, error)
+}
+
+func (r *taskStore) UpdateWhere(ctx context.Context, filter query.Expr, patch query.Patch, afterID string, limit int) ([]Task, int64, error) {
+	condition, err := query.Compile(filter, query.TaskSchema)
+	if err != nil {
+		return nil, 0, err
+	}
+	values := make(map[string]interface{}, len(patch)+1)
+	for column, value := range patch {
+		values[column] = value
+	}
+	if _, ok := values["updated_at"]; !ok {
+		values["updated_at"] = time.Now()
+	}
+
+	var tasks []Task
+	var updated int64
+	err = r.run(ctx, false, func(ctx context.Context) error {
+		return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+			var ids []string
+			err := tx.Model(&Task{}).Where(condition).Where("id > ?", afterID).
+				Order("id").Limit(limit).Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			// The filter is checked again in case a task changed since it
+			// was selected.
+			result := tx.Model(&Task{}).Where(condition).Where("id IN ?", ids).Updates(values)
+			if result.Error != nil {
+				return result.Error
+			}
+			updated = result.RowsAffected
+			return tx.Where("id IN ?", ids).Order("id").Find(&tasks).Error
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return tasks, updated, nil
+}
@@ -0,0 +1,214 @@
+// Package resilience protects calls to the service's dependencies with
+// retries and circuit breakers, publishing their metrics at /debug/vars
+// under "dependencies".
+package resilience
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Breaker states.
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// ErrOpen is returned for calls rejected by an open circuit breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// dependencyMetrics holds the metrics of each dependency.
+var dependencyMetrics = expvar.NewMap("dependencies")
+
+// Metrics returns the metrics of the dependency name, e.g. to count the calls
+// it skipped.
+func Metrics(name string) *expvar.Map {
+	if m, ok := dependencyMetrics.Get(name).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	dependencyMetrics.Set(name, m)
+	return m
+}
+
+// Breaker is a circuit breaker. After a number of consecutive failures it
+// opens and rejects calls for a while, sparing a dependency that is down and
+// callers that would wait for it. It then lets a single call through, and
+// closes again if that succeeds. It is safe for concurrent use.
+type Breaker struct {
+	threshold   int
+	openTimeout time.Duration
+	// isFailure tells which errors count against the dependency.
+	isFailure func(error) bool
+	metrics   *expvar.Map
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker returns a breaker for the dependency name that opens after
+// threshold consecutive failures, as told by isFailure, and stays open for
+// openTimeout. A threshold of zero never opens.
+func NewBreaker(name string, threshold int, openTimeout time.Duration, isFailure func(error) bool) *Breaker {
+	b := &Breaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		isFailure:   isFailure,
+		metrics:     Metrics(name),
+		state:       StateClosed,
+	}
+	b.metrics.Set("breaker_state", expvar.Func(func() interface{} { return b.State() }))
+	return b
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Do calls fn unless the breaker is open, and records the outcome. Failures
+// once ctx is done, e.g. because the caller gave up, say nothing about the
+// dependency and aren't recorded.
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	recorded := false
+	// A probe that panics or isn't recorded must not keep the breaker
+	// from probing again.
+	defer func() {
+		if probe && !recorded {
+			b.release()
+		}
+	}()
+	err = fn()
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	b.record(err != nil && b.isFailure(err))
+	recorded = true
+	return err
+}
+
+// allow returns ErrOpen if the call is rejected, and reports whether it is
+// the probe of a half-open breaker.
+func (b *Breaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return false, nil
+	}
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			break
+		}
+		b.state = StateHalfOpen
+		fallthrough
+	case StateHalfOpen:
+		if b.probing {
+			break
+		}
+		b.probing = true
+		return true, nil
+	default:
+		return false, nil
+	}
+	b.metrics.Add("breaker_rejected", 1)
+	return false, ErrOpen
+}
+
+// release lets another call probe, without recording an outcome.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return
+	}
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.state = StateClosed
+		return
+	}
+	b.failures++
+	b.metrics.Add("failures", 1)
+	if b.state == StateHalfOpen || (b.state == StateClosed && b.failures >= b.threshold) {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+		b.metrics.Add("breaker_opened", 1)
+	}
+}
+
+// Retry retries failed calls with exponential backoff and jitter.
+type Retry struct {
+	// MaxAttempts includes the first call; one or less disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// MaxDelay caps the delay between attempts; zero leaves it uncapped.
+	MaxDelay time.Duration
+	metrics  *expvar.Map
+}
+
+// NewRetry returns a retry policy for the dependency name.
+func NewRetry(name string, maxAttempts int, baseDelay, maxDelay time.Duration) *Retry {
+	return &Retry{MaxAttempts: maxAttempts, BaseDelay: baseDelay, MaxDelay: maxDelay, metrics: Metrics(name)}
+}
+
+// Do calls fn until it succeeds, fails with an error retryable doesn't
+// accept, or the attempts are used up. It stops waiting when ctx is done.
+func (r *Retry) Do(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	delay := r.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		switch {
+		case err == nil:
+			return nil
+		case !retryable(err):
+			// A retried call may still fail for good, e.g. on a constraint.
+			if attempt > 1 {
+				r.metrics.Add("retries_stopped", 1)
+			}
+			return err
+		case attempt >= r.MaxAttempts:
+			if attempt > 1 {
+				r.metrics.Add("retries_exhausted", 1)
+			}
+			return err
+		}
+		r.metrics.Add("retries", 1)
+
+		// Full jitter keeps clients that failed together from retrying
+		// together.
+		wait := time.Duration(rand.Int63n(int64(delay) + 1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if r.MaxDelay > 0 {
+			delay = min(delay, r.MaxDelay)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"testing"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+// TestRetryMetrics checks which outcomes of retried calls are counted as
+// exhausted retries.
+func TestRetryMetrics(t *testing.T) {
+	for i, tc := range []struct {
+		name string
+		// errs are the errors of the successive attempts; the last one
+		// repeats.
+		errs          []error
+		wantErr       error
+		wantRetries   int64
+		wantExhausted int64
+		wantStopped   int64
+	}{
+		{"succeeds", []error{nil}, nil, 0, 0, 0},
+		{"succeeds on retry", []error{errTransient, nil}, nil, 1, 0, 0},
+		{"fails for good at once", []error{errPermanent}, errPermanent, 0, 0, 0},
+		{"fails for good on retry", []error{errTransient, errPermanent}, errPermanent, 1, 0, 1},
+		{"exhausts the attempts", []error{errTransient}, errTransient, 2, 1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRetry(fmt.Sprintf("test-retry-%d", i), 3, 0, 0)
+			attempt := 0
+			err := r.Do(context.Background(), func(err error) bool { return err == errTransient }, func() error {
+				err := tc.errs[min(attempt, len(tc.errs)-1)]
+				attempt++
+				return err
+			})
+			if err != tc.wantErr {
+				t.Fatalf("Do = %v, want %v", err, tc.wantErr)
+			}
+			for name, want := range map[string]int64{
+				"retries":           tc.wantRetries,
+				"retries_exhausted": tc.wantExhausted,
+				"retries_stopped":   tc.wantStopped,
+			} {
+				var got int64
+				if v, ok := r.metrics.Get(name).(*expvar.Int); ok {
+					got = v.Value()
+				}
+				if got != want {
+					t.Errorf("%s = %d, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// readYourWritesCookie holds the time, in Unix milliseconds, until which the
+// client's requests read from the primary database.
+const readYourWritesCookie = "read_your_writes"
+
+// readYourWrites makes reads after a write see it, even with read replicas:
+// later reads of the writing request go to the primary, and so do those of
+// the client's requests within window after it, which carry a cookie set on
+// the response of the write.
+func readYourWrites(window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := repositories.WithReadYourWrites(r.Context(), wroteRecently(r, window))
+			if window > 0 {
+				w = &writeTracker{ResponseWriter: w, r: r.WithContext(ctx), window: window}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// wroteRecently reports whether the request carries a cookie set by a write
+// less than window ago. Expiry times further away than window are ignored.
+func wroteRecently(r *http.Request, window time.Duration) bool {
+	cookie, err := r.Cookie(readYourWritesCookie)
+	if err != nil || window <= 0 {
+		return false
+	}
+	until, err := strconv.ParseInt(cookie.Value, 10, 64)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	return until > now.UnixMilli() && until <= now.Add(window).UnixMilli()
+}
+
+// writeTracker sets the read-your-writes cookie when the response starts,
+// if the request wrote by then.
+type writeTracker struct {
+	http.ResponseWriter
+	r       *http.Request
+	window  time.Duration
+	started bool
+}
+
+func (t *writeTracker) WriteHeader(status int) {
+	t.start()
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *writeTracker) Write(b []byte) (int, error) {
+	t.start()
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses, such as exports, flushable.
+func (t *writeTracker) Flush() {
+	t.start()
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (t *writeTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *writeTracker) start() {
+	if t.started {
+		return
+	}
+	t.started = true
+	if !repositories.Wrote(t.r.Context()) {
+		return
+	}
+	http.SetCookie(t.ResponseWriter, &http.Cookie{
+		Name:     readYourWritesCookie,
+		Value:    strconv.FormatInt(time.Now().Add(t.window).UnixMilli(), 10),
+		Path:     "/",
+		MaxAge:   int((t.window + time.Second - 1) / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -1,18 +1,37 @@
 package routes
 
 import (
+	"expvar"
+
+	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/handlers"
 	"github.com/drive-deep/task-microservice/services"
 	"github.com/gorilla/mux"
 )
 
-func RegisterRoutes(router *mux.Router, taskService services.TaskService) {
-	taskHandler := handlers.NewTaskHandler(taskService)
+func RegisterRoutes(router *mux.Router, taskService services.TaskService, cfg *config.Config) {
+	taskHandler := handlers.NewTaskHandler(taskService, cfg)
+
+	// Reads after a write see it, even with read replicas.
+	router.Use(readYourWrites(cfg.Database.ReadYourWritesWindow))
 
 	// Define the routes
 	router.HandleFunc("/tasks", taskHandler.CreateTask).Methods("POST")
 	router.HandleFunc("/tasks", taskHandler.GetAllTasks).Methods("GET")
+	router.HandleFunc("/tasks:batch", taskHandler.BatchTasks).Methods("POST")
+	router.HandleFunc("/tasks:update-where", taskHandler.UpdateTasksWhere).Methods("POST")
+	router.HandleFunc("/tasks/search", taskHandler.SearchTasks).Methods("GET")
+	router.HandleFunc("/tasks/export", taskHandler.ExportTasks).Methods("GET")
+	router.HandleFunc("/tasks/import", taskHandler.ImportTasks).Methods("POST")
 	router.HandleFunc("/tasks/{id}", taskHandler.GetTask).Methods("GET")
 	router.HandleFunc("/tasks/{id}", taskHandler.UpdateTask).Methods("PUT")
 	router.HandleFunc("/tasks/{id}", taskHandler.DeleteTask).Methods("DELETE")
+	router.HandleFunc("/jobs/{id}", taskHandler.GetJob).Methods("GET")
+}
+
+// RegisterAdminRoutes registers the routes of the admin listener, which is
+// kept off the public API.
+func RegisterAdminRoutes(router *mux.Router) {
+	// Metrics, e.g. of archiving, in the expvar JSON format.
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 }
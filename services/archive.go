@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// ErrArchiveUnsupported is returned when the repository cannot archive
+// tasks.
+var ErrArchiveUnsupported = errors.New("archiving is not supported by this repository")
+
+// archiveMetrics are published at /debug/vars under "task_archive".
+var archiveMetrics = expvar.NewMap("task_archive")
+
+// ArchiveOptions controls ArchiveTasks.
+type ArchiveOptions struct {
+	// Statuses are the terminal statuses of tasks that may be archived.
+	Statuses []string
+	// Age is how long ago tasks must have been last updated.
+	Age time.Duration
+	// BatchSize is the number of tasks moved per transaction.
+	BatchSize int
+	// MaxBatches bounds the batches of a run; zero means no limit.
+	MaxBatches int
+}
+
+// ArchiveTasks moves tasks in a terminal status not updated for the given age
+// to the archive, a batch at a time so no transaction grows large, and
+// removes them from the cache. It returns the number of tasks archived.
+func (s *TaskService) ArchiveTasks(ctx context.Context, opts ArchiveOptions) (int, error) {
+	archiver, ok := s.repo.(repositories.TaskArchiver)
+	if !ok {
+		return 0, ErrArchiveUnsupported
+	}
+	if len(opts.Statuses) == 0 || opts.Age <= 0 || opts.BatchSize <= 0 {
+		return 0, fmt.Errorf("archiving requires statuses, a positive age and a positive batch size")
+	}
+
+	start := time.Now()
+	cutoff := start.Add(-opts.Age)
+	archived := 0
+	err := func() error {
+		for batch := 0; opts.MaxBatches <= 0 || batch < opts.MaxBatches; batch++ {
+			ids, err := archiver.Archive(ctx, opts.Statuses, cutoff, opts.BatchSize)
+			if err != nil {
+				return err
+			}
+			archived += len(ids)
+			archiveMetrics.Add("tasks_archived", int64(len(ids)))
+			// Unlike deleted tasks, archived ones aren't marked missing,
+			// since they can still be read by ID.
+			if err := s.cache.DeleteTasks(ctx, ids); err != nil {
+				log.Printf("Failed to drop %d archived tasks from the cache: %v", len(ids), err)
+			}
+			if len(ids) < opts.BatchSize {
+				return nil
+			}
+		}
+		return nil
+	}()
+
+	archiveMetrics.Add("runs", 1)
+	if err != nil {
+		archiveMetrics.Add("failures", 1)
+	}
+	lastArchived, lastRun, lastSeconds := new(expvar.Int), new(expvar.Int), new(expvar.Float)
+	lastArchived.Set(int64(archived))
+	lastRun.Set(start.Unix())
+	lastSeconds.Set(time.Since(start).Seconds())
+	archiveMetrics.Set("last_run_archived", lastArchived)
+	archiveMetrics.Set("last_run_timestamp", lastRun)
+	archiveMetrics.Set("last_run_seconds", lastSeconds)
+	return archived, err
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// Batch modes: an atomic batch is applied entirely or not at all, while a
+// best-effort batch applies every operation that succeeds.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+var (
+	// ErrBatchTooLarge is returned for batches above the configured size.
+	ErrBatchTooLarge = errors.New("batch too large")
+	// ErrInvalidOperation is the error of malformed batch operations.
+	ErrInvalidOperation = errors.New("invalid operation")
+	// ErrNotApplied is the error of operations of an atomic batch that was
+	// rolled back because another operation failed.
+	ErrNotApplied = errors.New("not applied: another operation of the atomic batch failed")
+	// ErrBatchUnsupported is returned for atomic batches when the
+	// repository cannot write batches.
+	ErrBatchUnsupported = errors.New("atomic batches are not supported by this repository")
+)
+
+// BatchOperation creates or updates Task, or deletes the task with ID. Task
+// must have an ID for creates too, as results and later operations of the
+// batch refer to tasks by ID.
+type BatchOperation struct {
+	Op   string `json:"op"`
+	Task *Task  `json:"task,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// BatchRequest is a list of writes applied together, the body of
+// POST /tasks:batch and of task_batch messages. Mode defaults to atomic.
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the outcome of an operation: the ID of the task, the task
+// written by a create or update, or the error that kept it from being
+// applied.
+type BatchResult struct {
+	Op   string
+	ID   string
+	Task *Task
+	Err  error
+}
+
+// ApplyBatch applies the operations of req in order, with one transaction
+// and one cache round trip for the whole batch where the repository and
+// cache allow. It returns a result per operation; an error means none was
+// attempted.
+func (s *TaskService) ApplyBatch(ctx context.Context, req BatchRequest) ([]BatchResult, error) {
+	atomic := true
+	switch req.Mode {
+	case BatchAtomic, "":
+	case BatchBestEffort:
+		atomic = false
+	default:
+		return nil, fmt.Errorf("%w: unknown batch mode %q", ErrInvalidOperation, req.Mode)
+	}
+	if s.maxBatch > 0 && len(req.Operations) > s.maxBatch {
+		return nil, fmt.Errorf("%w: %d operations, at most %d allowed", ErrBatchTooLarge, len(req.Operations), s.maxBatch)
+	}
+
+	results := make([]BatchResult, len(req.Operations))
+	var ops []repositories.BatchOp[Task]
+	var indexes []int
+	for i, op := range req.Operations {
+		repoOp, err := batchOp(op)
+		results[i] = BatchResult{Op: op.Op, ID: repoOp.ID, Task: repoOp.Entity, Err: err}
+		if err == nil {
+			ops = append(ops, repoOp)
+			indexes = append(indexes, i)
+		}
+	}
+	if atomic && len(ops) < len(results) {
+		return notApplied(results), nil
+	}
+
+	errs, err := s.writeBatch(ctx, ops, atomic)
+	if err != nil && !errors.Is(err, repositories.ErrBatchRolledBack) {
+		return nil, err
+	}
+	for i, opErr := range errs {
+		results[indexes[i]].Err = opErr
+	}
+	if err != nil {
+		return notApplied(results), nil
+	}
+
+	s.cacheBatch(ctx, results)
+	return results, nil
+}
+
+// writeBatch applies ops with the repository's batch support, or else one by
+// one.
+func (s *TaskService) writeBatch(ctx context.Context, ops []repositories.BatchOp[Task], atomic bool) ([]error, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	if writer, ok := s.repo.(repositories.BatchWriter[Task]); ok {
+		return writer.WriteBatch(ctx, ops, atomic)
+	}
+	if atomic {
+		return nil, ErrBatchUnsupported
+	}
+	errs := make([]error, len(ops))
+	for i, op := range ops {
+		switch op.Kind {
+		case repositories.BatchCreate:
+			errs[i] = s.repo.Create(ctx, op.Entity)
+		case repositories.BatchUpdate:
+			errs[i] = s.repo.Update(ctx, op.Entity)
+		case repositories.BatchDelete:
+			errs[i] = s.repo.Delete(ctx, op.ID)
+		}
+	}
+	return errs, nil
+}
+
+// cacheBatch writes the final state of every task the batch changed to the
+// cache. The batch is already applied, so failures are only logged.
+func (s *TaskService) cacheBatch(ctx context.Context, results []BatchResult) {
+	final := make(map[string]*Task)
+	var order []string
+	for _, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		if _, seen := final[res.ID]; !seen {
+			order = append(order, res.ID)
+		}
+		final[res.ID] = res.Task
+	}
+
+	var tasks []Task
+	var deleted []string
+	for _, id := range order {
+		if task := final[id]; task != nil {
+			tasks = append(tasks, *task)
+		} else {
+			deleted = append(deleted, id)
+		}
+	}
+	if err := s.cache.WriteBatch(ctx, tasks, deleted); err != nil {
+		log.Printf("Failed to cache batch of %d tasks: %v", len(order), err)
+	}
+}
+
+// batchOp validates op and converts it to a repository operation.
+func batchOp(op BatchOperation) (repositories.BatchOp[Task], error) {
+	switch op.Op {
+	case repositories.BatchCreate, repositories.BatchUpdate:
+		if op.Task == nil || op.Task.ID == "" {
+			return repositories.BatchOp[Task]{}, fmt.Errorf("%w: %s requires a task with an id", ErrInvalidOperation, op.Op)
+		}
+		task := *op.Task
+		return repositories.BatchOp[Task]{Kind: op.Op, Entity: &task, ID: task.ID}, nil
+	case repositories.BatchDelete:
+		if op.ID == "" {
+			return repositories.BatchOp[Task]{}, fmt.Errorf("%w: delete requires an id", ErrInvalidOperation)
+		}
+		return repositories.BatchOp[Task]{Kind: op.Op, ID: op.ID}, nil
+	}
+	return repositories.BatchOp[Task]{}, fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, op.Op)
+}
+
+// notApplied marks the operations of a rolled back atomic batch that didn't
+// fail themselves.
+func notApplied(results []BatchResult) []BatchResult {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = ErrNotApplied
+		}
+	}
+	return results
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// ErrRebuildUnsupported is returned when the repository cannot stream tasks.
+var ErrRebuildUnsupported = errors.New("cache rebuild is not supported by this repository")
+
+// RebuildOptions controls RebuildCache.
+type RebuildOptions struct {
+	// BatchSize is the number of tasks read per query.
+	BatchSize int
+	// Rate limits the tasks read per second; zero means no limit.
+	Rate int
+	// Progress, if set, is called after every batch with the number of
+	// tasks cached so far and the estimated total.
+	Progress func(done int, total int64)
+}
+
+// RebuildResult describes a finished rebuild.
+type RebuildResult struct {
+	Tasks int
+	// Complete reports whether the cache now holds every task, so filtered
+	// listings are served from it. It is false if the cache is too small for
+	// all tasks or anything was evicted during the rebuild.
+	Complete bool
+}
+
+// RebuildCache streams every task from the repository into the cache in
+// batches. Tasks already cached are kept, since they were written by updates
+// that may be newer than the batch read.
+func (s *TaskService) RebuildCache(ctx context.Context, opts RebuildOptions) (RebuildResult, error) {
+	var result RebuildResult
+	reader, ok := s.repo.(repositories.BatchReader[Task])
+	if !ok {
+		return result, ErrRebuildUnsupported
+	}
+	if opts.BatchSize <= 0 {
+		return result, fmt.Errorf("batch size must be positive")
+	}
+
+	total, err := s.repo.Count(ctx, nil, true)
+	if err != nil {
+		return result, err
+	}
+	token, err := s.cache.BeginRebuild(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	after := ""
+	for {
+		tasks, err := reader.GetBatch(ctx, after, opts.BatchSize)
+		if err != nil {
+			return result, err
+		}
+		for _, task := range tasks {
+			if err := s.cache.FillTask(ctx, task); err != nil {
+				return result, err
+			}
+		}
+		result.Tasks += len(tasks)
+		if opts.Progress != nil {
+			opts.Progress(result.Tasks, total)
+		}
+		if len(tasks) < opts.BatchSize {
+			break
+		}
+		after = tasks[len(tasks)-1].ID
+
+		if opts.Rate > 0 {
+			// Pace reads so that on average no more than Rate tasks are
+			// read per second since the start.
+			due := start.Add(time.Duration(result.Tasks) * time.Second / time.Duration(opts.Rate))
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		}
+	}
+
+	result.Complete, err = s.cache.CompleteRebuild(ctx, token)
+	return result, err
+}
+
+// CacheIsComplete reports whether the cache holds every task.
+func (s *TaskService) CacheIsComplete(ctx context.Context) (bool, error) {
+	return s.cache.IsComplete(ctx)
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drive-deep/task-microservice/config"
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// Job states.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Kinds of jobs, which determine the type of their result.
+const (
+	jobUpdateWhere = "update_where"
+	jobImport      = "import"
+)
+
+// Defaults for config.JobsConfig.
+const (
+	defaultJobRetention  = time.Hour
+	defaultJobStaleAfter = time.Minute
+)
+
+// staleJobReason is the error of jobs whose instance stopped reporting.
+const staleJobReason = "the instance running the job stopped responding"
+
+// ErrJobNotFound is returned for unknown or expired job IDs.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is an operation that runs in the background after the request that
+// started it returned. Jobs are stored through the repository when it
+// implements repositories.JobStore, so every instance can report them and
+// they outlive the instance running them; otherwise they are kept in memory.
+type Job struct {
+	ID    string
+	Kind  string
+	State string
+	// Total is the amount of work expected and Done the amount finished so
+	// far, in units that depend on the kind, e.g. tasks.
+	Total int64
+	Done  int64
+	// Result is set when the job succeeded and Err when it failed.
+	Result     interface{}
+	Err        error
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// jobRegistry runs jobs and keeps their state in a store. Running jobs send
+// a heartbeat; those that miss it for staleAfter are reported failed.
+// Finished jobs are kept for the retention period. It is safe for concurrent
+// use.
+type jobRegistry struct {
+	store      repositories.JobStore
+	retention  time.Duration
+	staleAfter time.Duration
+}
+
+func newJobRegistry(store repositories.JobStore, cfg config.JobsConfig) *jobRegistry {
+	r := &jobRegistry{store: store, retention: cfg.Retention, staleAfter: cfg.StaleAfter}
+	if r.retention <= 0 {
+		r.retention = defaultJobRetention
+	}
+	if r.staleAfter <= 0 {
+		r.staleAfter = defaultJobStaleAfter
+	}
+	return r
+}
+
+// start runs fn in the background, detached from the cancellation of ctx.
+// fn reports progress by calling its progress function with the work done.
+func (r *jobRegistry) start(ctx context.Context, kind string, total int64, fn func(ctx context.Context, progress func(done int64)) (interface{}, error)) (Job, error) {
+	b := make([]byte, 8)
+	if _, err := crand.Read(b); err != nil {
+		return Job{}, err
+	}
+	r.prune(ctx)
+	now := time.Now().UTC()
+	record := repositories.JobRecord{
+		ID:        hex.EncodeToString(b),
+		Kind:      kind,
+		State:     JobRunning,
+		Total:     total,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	if err := r.store.CreateJob(ctx, &record); err != nil {
+		return Job{}, err
+	}
+	go r.run(context.WithoutCancel(ctx), record, fn)
+	return newJob(record)
+}
+
+// run runs the job of record and stores its progress and outcome.
+func (r *jobRegistry) run(ctx context.Context, record repositories.JobRecord, fn func(ctx context.Context, progress func(done int64)) (interface{}, error)) {
+	var done atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(r.staleAfter / 3)
+		defer ticker.Stop()
+		heartbeat := record
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			heartbeat.Done = done.Load()
+			heartbeat.UpdatedAt = time.Now().UTC()
+			if err := r.store.UpdateJob(ctx, &heartbeat); err != nil {
+				log.Printf("Failed to record the progress of job %s: %v", record.ID, err)
+			}
+		}
+	}()
+
+	result, err := fn(ctx, func(n int64) { done.Store(n) })
+	close(stop)
+	wg.Wait()
+
+	now := time.Now().UTC()
+	record.Done = done.Load()
+	record.UpdatedAt = now
+	record.FinishedAt = &now
+	record.State = JobSucceeded
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(result)
+		record.Result = string(data)
+	}
+	if err != nil {
+		record.State = JobFailed
+		record.Error = err.Error()
+		log.Printf("Job %s (%s) failed: %v", record.ID, record.Kind, err)
+	}
+	if err := r.store.UpdateJob(ctx, &record); err != nil {
+		log.Printf("Failed to record the outcome of job %s: %v", record.ID, err)
+	}
+}
+
+// get returns the job with id, reporting it failed if it went stale.
+func (r *jobRegistry) get(ctx context.Context, id string) (Job, error) {
+	record, err := r.store.GetJob(ctx, id)
+	if err == nil && record.State == JobRunning && time.Since(record.UpdatedAt) > r.staleAfter {
+		if _, err := r.store.FailStaleJobs(ctx, time.Now().UTC().Add(-r.staleAfter), staleJobReason); err != nil {
+			return Job{}, err
+		}
+		record, err = r.store.GetJob(ctx, id)
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		return Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	if record.FinishedAt != nil && time.Since(*record.FinishedAt) > r.retention {
+		return Job{}, ErrJobNotFound
+	}
+	return newJob(*record)
+}
+
+// prune fails stale jobs and deletes those that finished longer than the
+// retention period ago. Failures are only logged, since the next job retries.
+func (r *jobRegistry) prune(ctx context.Context) {
+	now := time.Now().UTC()
+	if _, err := r.store.FailStaleJobs(ctx, now.Add(-r.staleAfter), staleJobReason); err != nil {
+		log.Printf("Failed to fail stale jobs: %v", err)
+	}
+	if _, err := r.store.DeleteJobs(ctx, now.Add(-r.retention)); err != nil {
+		log.Printf("Failed to delete expired jobs: %v", err)
+	}
+}
+
+// newJob returns the job stored as record, decoding its result by kind.
+func newJob(record repositories.JobRecord) (Job, error) {
+	job := Job{
+		ID:        record.ID,
+		Kind:      record.Kind,
+		State:     record.State,
+		Total:     record.Total,
+		Done:      record.Done,
+		StartedAt: record.StartedAt,
+	}
+	if record.FinishedAt != nil {
+		job.FinishedAt = *record.FinishedAt
+	}
+	if record.Error != "" {
+		job.Err = errors.New(record.Error)
+	}
+	if record.Result == "" {
+		return job, nil
+	}
+	var err error
+	switch record.Kind {
+	case jobUpdateWhere:
+		var result UpdateWhereResult
+		err = json.Unmarshal([]byte(record.Result), &result)
+		job.Result = result
+	case jobImport:
+		var result ImportResult
+		err = json.Unmarshal([]byte(record.Result), &result)
+		job.Result = result
+	default:
+		err = fmt.Errorf("unknown job kind %q", record.Kind)
+	}
+	return job, err
+}
+
+// GetJob returns the state of a job started by any instance.
+func (s *TaskService) GetJob(ctx context.Context, id string) (Job, error) {
+	return s.jobs.get(ctx, id)
+}
+
+// memoryJobStore keeps jobs in memory, for repositories that can't store
+// them. Only the instance running a job can report it.
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]repositories.JobRecord
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{jobs: make(map[string]repositories.JobRecord)}
+}
+
+func (m *memoryJobStore) CreateJob(ctx context.Context, job *repositories.JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *memoryJobStore) UpdateJob(ctx context.Context, job *repositories.JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.jobs[job.ID]; !ok || stored.State != JobRunning {
+		return repositories.ErrNotFound
+	}
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *memoryJobStore) GetJob(ctx context.Context, id string) (*repositories.JobRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	return &job, nil
+}
+
+func (m *memoryJobStore) FailStaleJobs(ctx context.Context, cutoff time.Time, reason string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var failed int64
+	for id, job := range m.jobs {
+		if job.State == JobRunning && job.UpdatedAt.Before(cutoff) {
+			now := time.Now().UTC()
+			job.State, job.Error, job.UpdatedAt, job.FinishedAt = JobFailed, reason, now, &now
+			m.jobs[id] = job
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+func (m *memoryJobStore) DeleteJobs(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
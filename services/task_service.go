@@ -1,50 +1,131 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
 	"github.com/drive-deep/task-microservice/cache"
+	"github.com/drive-deep/task-microservice/config"
 	"github.com/drive-deep/task-microservice/models"
+	"github.com/drive-deep/task-microservice/query"
 	"github.com/drive-deep/task-microservice/repositories"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type Task = models.Task
 
+// ErrSearchUnsupported is returned when the repository cannot search tasks.
+var ErrSearchUnsupported = errors.New("full-text search is not supported by this repository")
+
 type TaskService struct {
 	repo  repositories.Repository[Task]
 	cache cache.Cache
+	// loads coalesces concurrent cache misses for the same task ID into a
+	// single repository read. It is a pointer because the service is passed
+	// around by value.
+	loads *singleflight.Group
+	// maxBatch limits the operations of a batch; zero for no limit.
+	maxBatch int
+	bulk     config.BulkUpdateConfig
+	imports  config.ImportConfig
+	export   config.ExportConfig
+	jobs     *jobRegistry
 }
 
-func NewTaskService(repo repositories.Repository[Task], cache cache.Cache) *TaskService {
-	return &TaskService{repo, cache}
+// NewTaskService returns a service storing tasks in repo and caching them in
+// cache. Failed cache writes fail requests, so cache is expected to be a
+// cache.ResilientCache, which skips them in degraded mode.
+func NewTaskService(repo repositories.Repository[Task], cache cache.Cache, cfg *config.Config) *TaskService {
+	var jobs repositories.JobStore = newMemoryJobStore()
+	if store, ok := repo.(repositories.JobStore); ok {
+		jobs = store
+	}
+	return &TaskService{
+		repo:     repo,
+		cache:    cache,
+		loads:    &singleflight.Group{},
+		maxBatch: cfg.Server.MaxBatchSize,
+		bulk:     cfg.BulkUpdate,
+		imports:  cfg.Import,
+		export:   cfg.Export,
+		jobs:     newJobRegistry(jobs, cfg.Jobs),
+	}
 }
 
-func (s *TaskService) CreateTask(entity *Task) error {
-	if err := s.repo.Create(entity); err != nil {
+func (s *TaskService) CreateTask(ctx context.Context, entity *Task) error {
+	if err := s.repo.Create(ctx, entity); err != nil {
 		return err
 	}
-	if err := s.cache.AddTask(*entity); err != nil {
+	if err := s.cache.AddTask(ctx, *entity); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *TaskService) GetTaskByID(id string) (*Task, error) {
-	task, err := s.cache.GetTask(id)
+// GetTaskByID reads a task through the cache: misses are loaded from the
+// repository once, however many requests wait for them, and the result is
+// cached, including the fact that the task doesn't exist.
+func (s *TaskService) GetTaskByID(ctx context.Context, id string) (*Task, error) {
+	task, err := s.cache.GetTask(ctx, id)
 	if err == nil {
 		return &task, nil
 	}
-	return s.repo.GetByID(id)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, repositories.ErrNotFound
+	}
+
+	loaded := s.loads.DoChan(id, func() (interface{}, error) {
+		// The load is shared with every caller waiting for the same task, so
+		// it isn't cancelled when this one goes away; the dependency
+		// timeouts still bound it.
+		ctx := context.WithoutCancel(ctx)
+		task, err := s.repo.GetByID(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			if err := s.cache.MarkMissing(ctx, id); err != nil {
+				log.Printf("Failed to cache missing task %s: %v", id, err)
+			}
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Archived tasks are left out of the cache, which serves listings
+		// of active tasks.
+		if task.ArchivedAt != nil {
+			return task, nil
+		}
+		if err := s.cache.FillTask(ctx, *task); err != nil {
+			log.Printf("Failed to cache task %s: %v", id, err)
+		}
+		return task, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-loaded:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		// Callers may modify the task, so each gets its own copy.
+		task = *res.Val.(*Task)
+		return &task, nil
+	}
 }
 
-func (s *TaskService) GetAllTasks(filter map[string]interface{}, sort string, page, pageSize int) ([]Task, error) {
-	// Try to get cached tasks
-	if len(filter) == 0 && sort == "" {
-		if tasks, err := s.cache.GetPaginatedTasks(page, pageSize); err == nil && len(tasks) == pageSize {
+func (s *TaskService) GetAllTasks(ctx context.Context, opts repositories.ListOptions) ([]Task, error) {
+	// Try to get cached tasks. Cached tasks are complete, so they can serve
+	// any fieldset, but archived tasks are never cached.
+	if q, ok := cacheQuery(opts); ok {
+		if tasks, err := s.cache.GetFilteredTasks(ctx, q); err == nil {
 			return tasks, nil
 		}
 	}
 
 	// If not cached, get from repository
-	tasks, err := s.repo.GetAll(filter, sort, page, pageSize)
+	tasks, err := s.repo.GetAll(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -52,22 +133,91 @@ func (s *TaskService) GetAllTasks(filter map[string]interface{}, sort string, pa
 	return tasks, nil
 }
 
-func (s *TaskService) UpdateTask(entity *Task) error {
-	if err := s.repo.Update(entity); err != nil {
+// cacheQuery translates listing options to a cache query. Only status and
+// priority equality filters and a single sort on an indexed field can be
+// answered by the cache.
+func cacheQuery(opts repositories.ListOptions) (cache.ListQuery, bool) {
+	q := cache.ListQuery{Page: opts.Page, PageSize: opts.PageSize}
+	if len(opts.Sort) != 1 || opts.IncludeArchived {
+		return q, false
+	}
+	switch opts.Sort[0].Field {
+	case cache.SortCreatedAt, cache.SortUpdatedAt, cache.SortPriority:
+		q.SortBy, q.Desc = opts.Sort[0].Field, opts.Sort[0].Desc
+	default:
+		return q, false
+	}
+
+	if opts.Filter == nil {
+		return q, true
+	}
+	eq, ok := query.Equalities(opts.Filter)
+	if !ok {
+		return q, false
+	}
+	for field, values := range eq {
+		switch field {
+		case "status":
+			q.Status = values
+		case "priority":
+			for _, v := range values {
+				priority, err := strconv.Atoi(v)
+				if err != nil {
+					return q, false
+				}
+				q.Priority = append(q.Priority, priority)
+			}
+		default:
+			return q, false
+		}
+	}
+	return q, true
+}
+
+// CountTasks returns the number of tasks matching filter, optionally using a
+// planner estimate for unfiltered counts of active tasks.
+func (s *TaskService) CountTasks(ctx context.Context, filter query.Expr, estimate, includeArchived bool) (int64, error) {
+	if includeArchived {
+		archiver, ok := s.repo.(repositories.TaskArchiver)
+		if !ok {
+			return 0, ErrArchiveUnsupported
+		}
+		return archiver.CountWithArchived(ctx, filter)
+	}
+	return s.repo.Count(ctx, filter, estimate)
+}
+
+// SearchTasks runs a full-text search and returns a page of ranked results and
+// the total number of matches.
+func (s *TaskService) SearchTasks(ctx context.Context, query repositories.SearchQuery) ([]models.TaskSearchResult, int64, error) {
+	searcher, ok := s.repo.(repositories.TaskSearcher)
+	if !ok {
+		return nil, 0, ErrSearchUnsupported
+	}
+	return searcher.Search(ctx, query)
+}
+
+func (s *TaskService) UpdateTask(ctx context.Context, entity *Task) error {
+	if err := s.repo.Update(ctx, entity); err != nil {
 		return err
 	}
-	if err := s.cache.UpdateTask(*entity); err != nil {
+	if err := s.cache.UpdateTask(ctx, *entity); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *TaskService) DeleteTask(id string) error {
-	if err := s.repo.Delete(id); err != nil {
+func (s *TaskService) DeleteTask(ctx context.Context, id string) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
 		return err
 	}
-	if err := s.cache.DeleteTask(id); err != nil {
+	if err := s.cache.DeleteTask(ctx, id); err != nil {
 		return err
 	}
+	// Remembering the deletion keeps a read from a lagging replica from
+	// caching the task again.
+	if err := s.cache.MarkMissing(ctx, id); err != nil {
+		log.Printf("Failed to cache missing task %s: %v", id, err)
+	}
 	return nil
 }
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/drive-deep/task-microservice/query"
+	"github.com/drive-deep/task-microservice/repositories"
+	"github.com/drive-deep/task-microservice/taskio"
+)
+
+// Defaults of the import and export settings left at zero.
+const (
+	defaultExportBatchSize = 500
+	defaultImportChunkSize = 500
+)
+
+// maxImportErrors bounds the errors an import reports; the rest are only
+// counted.
+const maxImportErrors = 100
+
+// Limits of the task columns.
+const (
+	maxTitleLength  = 100
+	maxStatusLength = 20
+)
+
+// ErrAlreadyExists is the error of imported tasks whose ID is taken, unless
+// the import upserts.
+var ErrAlreadyExists = errors.New("task already exists")
+
+// ExportTasks calls fn with every task matching filter, optionally including
+// archived ones, a batch at a time in ID order. It reads from the repository
+// rather than the cache so exports are complete, and holds no more than a
+// batch in memory.
+func (s *TaskService) ExportTasks(ctx context.Context, filter query.Expr, includeArchived bool, fn func([]Task) error) error {
+	size := s.export.BatchSize
+	if size <= 0 {
+		size = defaultExportBatchSize
+	}
+	after := ""
+	for {
+		tasks, err := s.repo.GetAll(ctx, repositories.ListOptions{
+			Filter:          query.And(filter, query.Greater("id", after)),
+			Page:            1,
+			PageSize:        size,
+			IncludeArchived: includeArchived,
+		})
+		if err != nil {
+			return err
+		}
+		if len(tasks) > 0 {
+			if err := fn(tasks); err != nil {
+				return err
+			}
+		}
+		if len(tasks) < size {
+			return nil
+		}
+		after = tasks[len(tasks)-1].ID
+	}
+}
+
+// ImportOptions controls ImportTasks.
+type ImportOptions struct {
+	// Upsert updates tasks whose ID exists instead of failing them.
+	Upsert bool
+	// DryRun validates the tasks and reports what would be written.
+	DryRun bool
+}
+
+// ImportError is a task that wasn't imported, with the line it starts on.
+type ImportError struct {
+	Line int
+	ID   string
+	Err  error
+}
+
+// ImportResult reports an import, or what it would do for a dry run.
+type ImportResult struct {
+	Rows    int
+	Created int
+	Updated int
+	Failed  int
+	// Errors holds the first failures.
+	Errors []ImportError
+	// Job is set when the import runs in the background; its result is the
+	// final ImportResult.
+	Job *Job
+}
+
+// importErrorJSON is the form an ImportError is stored in with the result
+// of its job.
+type importErrorJSON struct {
+	Line int
+	ID   string
+	Err  string
+}
+
+func (e ImportError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(importErrorJSON{e.Line, e.ID, e.Err.Error()})
+}
+
+func (e *ImportError) UnmarshalJSON(data []byte) error {
+	var stored importErrorJSON
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*e = ImportError{Line: stored.Line, ID: stored.ID, Err: errors.New(stored.Err)}
+	return nil
+}
+
+func (r *ImportResult) fail(line int, id string, err error) {
+	r.Failed++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Line: line, ID: id, Err: err})
+	}
+}
+
+// ImportTasks validates records and writes the valid ones a chunk at a time,
+// keeping the rest out; a task failing doesn't stop the others. Imports of
+// more tasks than the configured threshold run as a job.
+func (s *TaskService) ImportTasks(ctx context.Context, records []taskio.Record, opts ImportOptions) (ImportResult, error) {
+	result := ImportResult{Rows: len(records)}
+	lines := make(map[string]int, len(records))
+	var valid []taskio.Record
+	for _, record := range records {
+		err := record.Err
+		if err == nil {
+			err = validateImported(record.Task)
+		}
+		if first, dup := lines[record.Task.ID]; err == nil && dup {
+			err = fmt.Errorf("duplicate id, first on line %d", first)
+		}
+		if err != nil {
+			result.fail(record.Line, record.Task.ID, err)
+			continue
+		}
+		lines[record.Task.ID] = record.Line
+		valid = append(valid, record)
+	}
+
+	if !opts.DryRun && s.imports.AsyncThreshold > 0 && len(valid) > s.imports.AsyncThreshold {
+		job, err := s.jobs.start(ctx, jobImport, int64(len(records)), func(ctx context.Context, progress func(int64)) (interface{}, error) {
+			return s.importTasks(ctx, valid, opts, result, progress)
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Job = &job
+		return result, nil
+	}
+	return s.importTasks(ctx, valid, opts, result, func(int64) {})
+}
+
+// importTasks writes valid records in chunks, adding to result. Progress
+// counts every record, the invalid ones first.
+func (s *TaskService) importTasks(ctx context.Context, valid []taskio.Record, opts ImportOptions, result ImportResult, progress func(int64)) (ImportResult, error) {
+	chunkSize := s.imports.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultImportChunkSize
+	}
+
+	done := result.Failed
+	for start := 0; start < len(valid); start += chunkSize {
+		chunk := valid[start:min(start+chunkSize, len(valid))]
+		if err := s.importChunk(ctx, chunk, opts, &result); err != nil {
+			return result, err
+		}
+		done += len(chunk)
+		progress(int64(done))
+	}
+	slices.SortStableFunc(result.Errors, func(a, b ImportError) int { return a.Line - b.Line })
+	return result, nil
+}
+
+func (s *TaskService) importChunk(ctx context.Context, chunk []taskio.Record, opts ImportOptions, result *ImportResult) error {
+	ids := make([]string, len(chunk))
+	for i, record := range chunk {
+		ids[i] = record.Task.ID
+	}
+	existing, err := s.repo.GetAll(ctx, repositories.ListOptions{
+		Filter:          query.In("id", ids...),
+		Page:            1,
+		PageSize:        len(ids),
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]Task, len(existing))
+	for _, task := range existing {
+		stored[task.ID] = task
+	}
+
+	var ops []repositories.BatchOp[Task]
+	var records []taskio.Record
+	for _, record := range chunk {
+		task := record.Task
+		old, exists := stored[task.ID]
+		if exists && old.ArchivedAt != nil {
+			// Archived IDs stay taken, even by upserts.
+			result.fail(record.Line, task.ID, repositories.ErrArchived)
+			continue
+		}
+		if exists && !opts.Upsert {
+			result.fail(record.Line, task.ID, ErrAlreadyExists)
+			continue
+		}
+		op := repositories.BatchOp[Task]{Kind: repositories.BatchCreate, Entity: &task, ID: task.ID}
+		if exists {
+			op.Kind = repositories.BatchUpdate
+			if task.CreatedAt.IsZero() {
+				task.CreatedAt = old.CreatedAt
+			}
+		}
+		ops = append(ops, op)
+		records = append(records, record)
+	}
+
+	errs := make([]error, len(ops))
+	if !opts.DryRun {
+		if errs, err = s.writeBatch(ctx, ops, false); err != nil {
+			return err
+		}
+	}
+
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Op: op.Kind, ID: op.ID, Task: op.Entity, Err: errs[i]}
+		switch {
+		case errs[i] != nil:
+			result.fail(records[i].Line, op.ID, errs[i])
+		case op.Kind == repositories.BatchCreate:
+			result.Created++
+		default:
+			result.Updated++
+		}
+	}
+	if !opts.DryRun {
+		s.cacheBatch(ctx, results)
+	}
+	return nil
+}
+
+// validateImported checks what the database would reject.
+func validateImported(task Task) error {
+	switch {
+	case task.ID == "":
+		return errors.New("missing id")
+	case utf8.RuneCountInString(task.Title) > maxTitleLength:
+		return fmt.Errorf("title longer than %d characters", maxTitleLength)
+	case utf8.RuneCountInString(task.Status) > maxStatusLength:
+		return fmt.Errorf("status longer than %d characters", maxStatusLength)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/drive-deep/task-microservice/query"
+	"github.com/drive-deep/task-microservice/repositories"
+)
+
+// Defaults of the bulk update settings left at zero.
+const (
+	defaultUpdateChunkSize  = 500
+	defaultUpdateSampleSize = 10
+)
+
+var (
+	// ErrTooManyMatches is returned when a bulk update would change more
+	// tasks than allowed.
+	ErrTooManyMatches = errors.New("filter matches too many tasks")
+	// ErrBulkUpdateUnsupported is returned when the repository cannot update
+	// tasks by filter.
+	ErrBulkUpdateUnsupported = errors.New("bulk updates are not supported by this repository")
+)
+
+// UpdateWhereRequest applies Patch to every task matching Filter, or with
+// DryRun only reports which tasks match.
+type UpdateWhereRequest struct {
+	Filter query.Expr
+	Patch  query.Patch
+	DryRun bool
+}
+
+// UpdateWhereResult describes a bulk update. Matched is counted before the
+// update starts; Updated is the number of tasks changed, which differs when
+// tasks changed meanwhile.
+type UpdateWhereResult struct {
+	Matched int64
+	Updated int64
+	// SampleIDs are the first matching task IDs of a dry run.
+	SampleIDs []string
+	// Job is set when the update runs in the background; its result is the
+	// final UpdateWhereResult.
+	Job *Job
+}
+
+// UpdateWhere applies a patch to every task matching a filter, refreshing the
+// cached copies of the tasks it changes. Updates of more tasks than the
+// configured threshold run as a job.
+func (s *TaskService) UpdateWhere(ctx context.Context, req UpdateWhereRequest) (UpdateWhereResult, error) {
+	var result UpdateWhereResult
+	updater, ok := s.repo.(repositories.BulkUpdater[Task])
+	if !ok {
+		return result, ErrBulkUpdateUnsupported
+	}
+	if req.Filter == nil {
+		return result, fmt.Errorf("%w: a filter is required", ErrInvalidOperation)
+	}
+	if len(req.Patch) == 0 {
+		return result, fmt.Errorf("%w: the patch is empty", ErrInvalidOperation)
+	}
+
+	matched, err := s.repo.Count(ctx, req.Filter, false)
+	if err != nil {
+		return result, err
+	}
+	result.Matched = matched
+	if s.bulk.MaxRows > 0 && matched > int64(s.bulk.MaxRows) {
+		return result, fmt.Errorf("%w: %d tasks match, at most %d may be updated at once", ErrTooManyMatches, matched, s.bulk.MaxRows)
+	}
+
+	if req.DryRun {
+		sampleSize := s.bulk.SampleSize
+		if sampleSize <= 0 {
+			sampleSize = defaultUpdateSampleSize
+		}
+		sample, err := s.repo.GetAll(ctx, repositories.ListOptions{
+			Filter:   req.Filter,
+			Fields:   query.Fields{query.TaskSchema["id"]},
+			Page:     1,
+			PageSize: sampleSize,
+		})
+		if err != nil {
+			return result, err
+		}
+		for _, task := range sample {
+			result.SampleIDs = append(result.SampleIDs, task.ID)
+		}
+		return result, nil
+	}
+
+	if s.bulk.AsyncThreshold > 0 && matched > int64(s.bulk.AsyncThreshold) {
+		job, err := s.jobs.start(ctx, jobUpdateWhere, matched, func(ctx context.Context, progress func(int64)) (interface{}, error) {
+			return s.updateWhere(ctx, updater, req, matched, progress)
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Job = &job
+		return result, nil
+	}
+	return s.updateWhere(ctx, updater, req, matched, func(int64) {})
+}
+
+// updateWhere updates the matching tasks a chunk at a time, so no
+// transaction holds many rows locked, and stops at the configured maximum
+// even if more tasks started matching.
+func (s *TaskService) updateWhere(ctx context.Context, updater repositories.BulkUpdater[Task], req UpdateWhereRequest, matched int64, progress func(int64)) (UpdateWhereResult, error) {
+	result := UpdateWhereResult{Matched: matched}
+	chunkSize := s.bulk.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultUpdateChunkSize
+	}
+
+	var done int64
+	after := ""
+	for {
+		limit := chunkSize
+		if s.bulk.MaxRows > 0 {
+			limit = min(limit, s.bulk.MaxRows-int(result.Updated))
+		}
+		if limit <= 0 {
+			return result, nil
+		}
+		tasks, updated, err := updater.UpdateWhere(ctx, req.Filter, req.Patch, after, limit)
+		if err != nil {
+			return result, err
+		}
+		result.Updated += updated
+		if len(tasks) == 0 {
+			return result, nil
+		}
+		s.refreshCached(ctx, tasks)
+		done += int64(len(tasks))
+		progress(done)
+		after = tasks[len(tasks)-1].ID
+	}
+}
+
+// refreshCached replaces the cached copies of tasks changed in the database,
+// dropping them if that fails.
+func (s *TaskService) refreshCached(ctx context.Context, tasks []Task) {
+	err := s.cache.WriteBatch(ctx, tasks, nil)
+	if err == nil {
+		return
+	}
+	log.Printf("Failed to cache %d updated tasks: %v", len(tasks), err)
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	if err := s.cache.DeleteTasks(ctx, ids); err != nil {
+		log.Printf("Failed to drop %d updated tasks from the cache: %v", len(ids), err)
+	}
+}
@@ -0,0 +1,187 @@
+package taskio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// maxLineSize bounds an NDJSON line.
+const maxLineSize = 1 << 20
+
+// ErrTooManyRecords is returned by ReadAll for inputs above the record limit.
+var ErrTooManyRecords = errors.New("too many records")
+
+// Record is a task read from an import. Line is where the record starts, and
+// Err is set when it couldn't be read as a task.
+type Record struct {
+	Line int
+	Task models.Task
+	Err  error
+}
+
+// Mapping maps CSV columns or NDJSON keys to task fields, for inputs that
+// don't use the field names. Columns and keys that are neither mapped nor
+// named after a field are ignored.
+type Mapping map[string]string
+
+// ParseMapping parses "column:field" pairs.
+func ParseMapping(pairs []string) (Mapping, error) {
+	mapping := make(Mapping, len(pairs))
+	for _, pair := range pairs {
+		column, field, ok := strings.Cut(pair, ":")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected column:field", pair)
+		}
+		if !slices.Contains(Fields, field) {
+			return nil, fmt.Errorf("invalid mapping %q: unknown field %q", pair, field)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// field returns the task field of a column, or "" to ignore it.
+func (m Mapping) field(column string) string {
+	if field, ok := m[column]; ok {
+		return field
+	}
+	if slices.Contains(Fields, column) {
+		return column
+	}
+	return ""
+}
+
+// ReadAll reads every record of r in format. Malformed records are returned
+// with their error; an error is only returned when the input can't be read
+// at all or has more than maxRecords records.
+func ReadAll(r io.Reader, format string, mapping Mapping, maxRecords int) ([]Record, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r, mapping, maxRecords)
+	case FormatNDJSON:
+		return readNDJSON(r, mapping, maxRecords)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// readCSV reads a header row naming the columns and a task per row.
+func readCSV(r io.Reader, mapping Mapping, maxRecords int) ([]Record, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	fields := make([]string, len(header))
+	for i, column := range header {
+		field := mapping.field(strings.TrimSpace(column))
+		if field != "" && slices.Contains(fields[:i], field) {
+			return nil, fmt.Errorf("invalid header: several columns map to %q", field)
+		}
+		fields[i] = field
+	}
+	if !slices.Contains(fields, "id") {
+		return nil, fmt.Errorf("invalid header: no column maps to \"id\"")
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if len(records) == maxRecords && maxRecords > 0 {
+			return nil, fmt.Errorf("%w: at most %d allowed", ErrTooManyRecords, maxRecords)
+		}
+		var record Record
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			record.Line, record.Err = parseErr.StartLine, parseErr.Err
+		} else {
+			record.Line, _ = reader.FieldPos(0)
+			for i, value := range row {
+				if fields[i] == "" {
+					continue
+				}
+				if err := setField(&record.Task, fields[i], value); err != nil {
+					record.Err = err
+					break
+				}
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// readNDJSON reads a JSON object per line, skipping blank lines.
+func readNDJSON(r io.Reader, mapping Mapping, maxRecords int) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var records []Record
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(records) == maxRecords && maxRecords > 0 {
+			return nil, fmt.Errorf("%w: at most %d allowed", ErrTooManyRecords, maxRecords)
+		}
+		record := Record{Line: line}
+		record.Task, record.Err = decodeObject(data, mapping)
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func decodeObject(data []byte, mapping Mapping) (models.Task, error) {
+	var task models.Task
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return task, fmt.Errorf("invalid JSON object: %w", err)
+	}
+	seen := make(map[string]string, len(object))
+	for key, raw := range object {
+		field := mapping.field(key)
+		if field == "" {
+			continue
+		}
+		if other, dup := seen[field]; dup {
+			return task, fmt.Errorf("keys %q and %q both map to %q", other, key, field)
+		}
+		seen[field] = key
+
+		// Strings are taken as they are and other values, e.g. numbers, as
+		// written.
+		value := string(raw)
+		if raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return task, err
+			}
+		} else if value == "null" {
+			value = ""
+		}
+		if err := setField(&task, field, value); err != nil {
+			return task, err
+		}
+	}
+	return task, nil
+}
@@ -0,0 +1,121 @@
+// Package taskio writes and reads tasks as CSV or newline-delimited JSON, the
+// formats of task exports and imports.
+package taskio
+
+import (
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// Supported formats.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Fields are the task fields by JSON name, in the order of CSV columns.
+var Fields = []string{"id", "title", "description", "status", "priority", "created_at", "updated_at"}
+
+// columns are the CSV columns of exports: Fields, then archived_at, empty for
+// active tasks. Imports ignore archived_at, like NDJSON's archived_at key,
+// since tasks are never imported archived.
+var columns = append(slices.Clone(Fields), "archived_at")
+
+// ContentType returns the media type of format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// FormatOf returns the format of a media type, or "" if none matches.
+func FormatOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// Valid reports whether format is supported.
+func Valid(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// values returns the fields of task as text, in the order of columns.
+func values(task models.Task) []string {
+	var archivedAt string
+	if task.ArchivedAt != nil {
+		archivedAt = task.ArchivedAt.Format(time.RFC3339Nano)
+	}
+	return []string{
+		task.ID,
+		task.Title,
+		task.Description,
+		task.Status,
+		strconv.Itoa(task.Priority),
+		task.CreatedAt.Format(time.RFC3339Nano),
+		task.UpdatedAt.Format(time.RFC3339Nano),
+		archivedAt,
+	}
+}
+
+// setField sets the field of task named name from its text. Empty values
+// leave the field at its zero value.
+func setField(task *models.Task, name, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch name {
+	case "id":
+		task.ID = value
+	case "title":
+		task.Title = value
+	case "description":
+		task.Description = value
+	case "status":
+		task.Status = value
+	case "priority":
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", value)
+		}
+		task.Priority = priority
+	case "created_at", "updated_at":
+		t, err := parseTime(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q, expected RFC 3339 or YYYY-MM-DD", name, value)
+		}
+		if name == "created_at" {
+			task.CreatedAt = t
+		} else {
+			task.UpdatedAt = t
+		}
+	default:
+		if !slices.Contains(Fields, name) {
+			return fmt.Errorf("unknown field %q", name)
+		}
+	}
+	return nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
@@ -0,0 +1,182 @@
+package taskio
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// testTime is a timestamp with the nanoseconds exports keep.
+var testTime = time.Date(2025, 2, 28, 12, 30, 0, 123456789, time.UTC)
+
+func TestReadAll(t *testing.T) {
+	day := time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		format  string
+		input   string
+		mapping Mapping
+		max     int
+		// want lists the records read, as line:id or line:error.
+		want    []string
+		wantErr string
+	}{
+		{"csv", FormatCSV, "id,title,priority\n1,first,2\n2,second,\n", nil, 0, []string{"2:1", "3:2"}, ""},
+		{"csv empty", FormatCSV, "", nil, 0, nil, ""},
+		{"csv header only", FormatCSV, "id,title\n", nil, 0, nil, ""},
+		{"csv header without id", FormatCSV, "title,status\nfirst,todo\n", nil, 0, nil, `no column maps to "id"`},
+		{"csv header mapping twice", FormatCSV, "id,key\n1,2\n", Mapping{"key": "id"}, 0, nil, `several columns map to "id"`},
+		{"csv mapped header", FormatCSV, "Key,Summary\n1,first\n", Mapping{"Key": "id", "Summary": "title"}, 0, []string{"2:1"}, ""},
+		{"csv unknown columns ignored", FormatCSV, "id,owner\n1,someone\n", nil, 0, []string{"2:1"}, ""},
+		{"csv missing id", FormatCSV, "id,title\n,untitled\n", nil, 0, []string{"2:"}, ""},
+		{"csv invalid priority", FormatCSV, "id,priority\n1,high\n2,1\n", nil, 0, []string{`2:invalid priority "high"`, "3:2"}, ""},
+		{"csv invalid time", FormatCSV, "id,created_at\n1,yesterday\n", nil, 0, []string{`2:invalid created_at "yesterday", expected RFC 3339 or YYYY-MM-DD`}, ""},
+		{"csv wrong number of fields", FormatCSV, "id,title\n1\n2,second\n", nil, 0, []string{"2:wrong number of fields", "3:2"}, ""},
+		{"csv bare quote", FormatCSV, "id,title\n1,a \"quoted\" title\n", nil, 0, []string{`2:bare " in non-quoted-field`}, ""},
+		{"csv multiline row", FormatCSV, "id,description\n1,\"two\nlines\"\n2,\n", nil, 0, []string{"2:1", "4:2"}, ""},
+		{"csv too many records", FormatCSV, "id\n1\n2\n3\n", nil, 2, nil, "too many records: at most 2 allowed"},
+		{"ndjson", FormatNDJSON, "{\"id\":\"1\",\"priority\":2}\n\n{\"id\":\"2\",\"title\":null}\n", nil, 0, []string{"1:1", "3:2"}, ""},
+		{"ndjson mapped keys", FormatNDJSON, `{"Key":"1","Summary":"first"}`, Mapping{"Key": "id", "Summary": "title"}, 0, []string{"1:1"}, ""},
+		{"ndjson keys mapping twice", FormatNDJSON, `{"id":"1","Key":"2"}`, Mapping{"Key": "id"}, 0, []string{`1:keys "id" and "Key" both map to "id"`}, ""},
+		{"ndjson missing id", FormatNDJSON, `{"title":"untitled"}`, nil, 0, []string{"1:"}, ""},
+		{"ndjson malformed line", FormatNDJSON, "{\"id\":\"1\"\n{\"id\":\"2\"}\n", nil, 0, []string{"1:invalid JSON object: unexpected end of JSON input", "2:2"}, ""},
+		{"ndjson invalid priority", FormatNDJSON, `{"id":"1","priority":"high"}`, nil, 0, []string{`1:invalid priority "high"`}, ""},
+		{"ndjson too many records", FormatNDJSON, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", nil, 1, nil, "too many records: at most 1 allowed"},
+		{"unknown format", "xml", "<task/>", nil, 0, nil, `unknown format "xml"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := ReadAll(strings.NewReader(tc.input), tc.format, tc.mapping, tc.max)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ReadAll = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, record := range records {
+				if record.Err != nil {
+					got = append(got, fmt.Sprintf("%d:%v", record.Line, record.Err))
+				} else {
+					got = append(got, fmt.Sprintf("%d:%s", record.Line, record.Task.ID))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("ReadAll read %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Values are converted to the field types.
+	records, err := ReadAll(strings.NewReader("id,priority,created_at\n1,3,2025-02-28\n"), FormatCSV, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task := records[0].Task; records[0].Err != nil || task.Priority != 3 || !task.CreatedAt.Equal(day) {
+		t.Fatalf("ReadAll read %+v, %v, want priority 3 created on %v", task, records[0].Err, day)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	task := models.Task{
+		ID:          "1",
+		Title:       "first, with a comma",
+		Description: "two\nlines",
+		Status:      "todo",
+		Priority:    2,
+		CreatedAt:   testTime,
+		UpdatedAt:   testTime.Add(time.Hour),
+	}
+	archivedAt := testTime.Add(2 * time.Hour)
+	archived := models.Task{ID: "2", Status: "done", CreatedAt: testTime, UpdatedAt: testTime, ArchivedAt: &archivedAt}
+	for _, tc := range []struct {
+		name   string
+		format string
+		tasks  []models.Task
+		want   string
+	}{
+		{"csv empty", FormatCSV, nil, "id,title,description,status,priority,created_at,updated_at,archived_at\n"},
+		{"csv", FormatCSV, []models.Task{task}, "id,title,description,status,priority,created_at,updated_at,archived_at\n" +
+			"1,\"first, with a comma\",\"two\nlines\",todo,2,2025-02-28T12:30:00.123456789Z,2025-02-28T13:30:00.123456789Z,\n"},
+		{"csv archived", FormatCSV, []models.Task{archived}, "id,title,description,status,priority,created_at,updated_at,archived_at\n" +
+			"2,,,done,0,2025-02-28T12:30:00.123456789Z,2025-02-28T12:30:00.123456789Z,2025-02-28T14:30:00.123456789Z\n"},
+		{"ndjson empty", FormatNDJSON, nil, ""},
+		{"ndjson", FormatNDJSON, []models.Task{task}, `{"id":"1","title":"first, with a comma","description":"two\nlines",` +
+			`"status":"todo","priority":2,"created_at":"2025-02-28T12:30:00.123456789Z","updated_at":"2025-02-28T13:30:00.123456789Z"}` + "\n"},
+		{"ndjson archived", FormatNDJSON, []models.Task{archived}, `{"id":"2","title":"","description":"","status":"done","priority":0,` +
+			`"created_at":"2025-02-28T12:30:00.123456789Z","updated_at":"2025-02-28T12:30:00.123456789Z","archived_at":"2025-02-28T14:30:00.123456789Z"}` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range tc.tasks {
+				if err := w.Write(task); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Fatalf("wrote %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+	if _, err := NewWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("NewWriter of an unknown format succeeded")
+	}
+}
+
+// TestRoundTrip checks that imports read back what exports write.
+func TestRoundTrip(t *testing.T) {
+	tasks := []models.Task{
+		{ID: "1", Title: "first", Description: "with \"quotes\", commas\nand lines", Status: "todo", Priority: 2,
+			CreatedAt: testTime, UpdatedAt: testTime.Add(time.Hour)},
+		// Imports ignore archived_at.
+		{ID: "2", Title: "second", Status: "done", Priority: 0, CreatedAt: testTime, UpdatedAt: testTime, ArchivedAt: &testTime},
+	}
+	for _, format := range []string{FormatCSV, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range tasks {
+				if err := w.Write(task); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			records, err := ReadAll(&buf, format, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tasks) {
+				t.Fatalf("read %d records, want %d", len(records), len(tasks))
+			}
+			for i, record := range records {
+				if record.Err != nil {
+					t.Fatalf("record %d: %v", i, record.Err)
+				}
+				got, want := record.Task, tasks[i]
+				if got.ArchivedAt != nil || got.ID != want.ID || got.Title != want.Title || got.Description != want.Description ||
+					got.Status != want.Status || got.Priority != want.Priority ||
+					!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+					t.Fatalf("read %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
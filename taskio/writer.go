@@ -0,0 +1,74 @@
+package taskio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/drive-deep/task-microservice/models"
+)
+
+// Writer writes tasks one at a time. Flush must be called after the last
+// task, and may be called in between to send what was written so far.
+type Writer interface {
+	Write(task models.Task) error
+	Flush() error
+}
+
+// NewWriter returns a Writer of format writing to w.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatNDJSON:
+		buf := bufio.NewWriter(w)
+		return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// csvWriter writes a header of the column names and a row per task.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(task models.Task) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write(values(task))
+}
+
+func (c *csvWriter) Flush() error {
+	// Empty exports still get a header.
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(columns)
+}
+
+// ndjsonWriter writes a JSON object per task and line.
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(task models.Task) error {
+	return n.enc.Encode(task)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return n.buf.Flush()
+}